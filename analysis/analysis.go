@@ -0,0 +1,146 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package analysis works out what happened during a run from its run file.
+// Like runfile, it doesn't depend on App Engine, so the website and offline tools analyze runs the same way.
+package analysis
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// The version of the analysis. It must be increased whenever a change to Analyze changes its results, so that the runs analyzed before the change get analyzed again.
+const Version = 11
+
+var ErrNoLines = errors.New("the run file doesn't contain any lines")
+
+type Map struct {
+	Name       string
+	Time       time.Duration // Real time, from the map's first line to the first line of the next map.
+	GameTime   time.Duration `datastore:",noindex" json:"game_time"`   // Time without loads.
+	Loads      int           `datastore:",noindex" json:"loads"`       // How many times a save was loaded during the map.
+	SampleRate float64       `datastore:",noindex" json:"sample_rate"` // Lines recorded a second.
+
+	Movement
+}
+
+// The results of analyzing a run.
+type Result struct {
+	Maps    []Map    `json:"maps"`
+	Players []string `json:"runners"`
+	Legs    []Leg    `json:"legs"`            // Who played which part of the run.
+	Flags   []Flag   `json:"flags,omitempty"` // Anything implausible about the run.
+	Idle    []Idle   `json:"idle,omitempty"`  // When the player stood still.
+
+	TotalTime time.Duration `datastore:",noindex" json:"total_time"` // Real time, which is the time of the last line plus the time lost to each new segment.
+	GameTime  time.Duration `datastore:",noindex" json:"game_time"`  // Time without loads.
+	Lines     int           `datastore:",noindex" json:"lines"`
+
+	Loads    int `datastore:",noindex" json:"loads"`    // How many times a save was loaded, including to start a new segment.
+	Segments int `datastore:",noindex" json:"segments"` // A run played in more than one segment is segmented.
+
+	SampleRate float64 `datastore:",noindex" json:"sample_rate"` // Lines recorded a second, which follows the frame rate.
+
+	Movement
+}
+
+// An Analyzer works out one section of a run's analysis. A new one is made for every run.
+// Begin is called with the run's header, Line with every line in order, and End once there are no more lines.
+// If the run file breaks partway through, End is called with complete set to false and the Analyzer should only fill in what it's sure of.
+// Lines are reused, so an Analyzer must not keep them after Line returns.
+type Analyzer interface {
+	Begin(header *runfile.Header)
+	Line(line *runfile.Line)
+	End(result *Result, complete bool)
+}
+
+var analyzers = []func() Analyzer{
+	newMapAnalyzer,
+	newPlayerAnalyzer,
+	newMovementAnalyzer,
+	newAnomalyAnalyzer,
+	newSanityAnalyzer,
+	newSampleRateAnalyzer,
+	newGameTimeAnalyzer,
+	newSaveLoadAnalyzer,
+	newIdleAnalyzer,
+}
+
+// Registers an analyzer that's run on every run, after the ones registered before it.
+// This should only be called during initialization.
+func RegisterAnalyzer(newAnalyzer func() Analyzer) {
+	if newAnalyzer == nil {
+		panic("analysis: RegisterAnalyzer newAnalyzer is nil")
+	}
+
+	analyzers = append(analyzers, newAnalyzer)
+}
+
+// Reports whether the run was played in more than one segment.
+func (r *Result) Segmented() bool {
+	return r.Segments > 1
+}
+
+// Analyzes every line of a run. The preamble and header must already have been read from r.
+// It stops at the first line that can't be read or whose numbers aren't finite. If any lines were read before that, the result so far is returned along with the error.
+// That result only contains the maps that were finished before the problem, and TotalTime only goes up to the last line that could be read.
+func Analyze(r *runfile.Reader, header *runfile.Header) (*Result, error) {
+	sections := make([]Analyzer, len(analyzers))
+	for i, newAnalyzer := range analyzers {
+		sections[i] = newAnalyzer()
+		sections[i].Begin(header)
+	}
+
+	result := new(Result)
+	clock := new(runClock)
+	end := func(complete bool) {
+		result.TotalTime = clock.since(0, 0)
+		for _, section := range sections {
+			section.End(result, complete)
+		}
+		sort.Stable(flagsByLine(result.Flags))
+	}
+
+	var runLine runfile.Line
+	for {
+		offset := r.Offset()
+		err := r.ReadLineInto(&runLine)
+		if err == io.EOF {
+			break
+		} else if err == nil && !lineIsFinite(&runLine) {
+			err = &runfile.DecodeError{Err: ErrNotFinite, Offset: offset, Line: result.Lines + 1}
+		}
+		if err != nil {
+			if result.Lines == 0 {
+				return nil, err
+			}
+			end(false)
+			return result, err
+		}
+
+		for _, section := range sections {
+			section.Line(&runLine)
+		}
+		clock.advance(runLine.Time)
+		result.Lines++
+	}
+	if result.Lines == 0 {
+		return nil, ErrNoLines
+	}
+
+	end(true)
+	return result, nil
+}
+
+// Reports whether a run whose analysis stopped with err can be kept as a partial run. It can if the run file broke after at least one map was finished.
+// result and err are what Analyze returned.
+func Salvageable(result *Result, err error) bool {
+	_, ok := err.(*runfile.DecodeError)
+	return ok && result != nil && len(result.Maps) > 0
+}
@@ -0,0 +1,426 @@
+package analysis
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// A game with Half-Life 2's limits, except that the test runs can be recorded at any rate.
+const testGame = 0xff
+
+func init() {
+	limits, _ := LimitsFor(0x00)
+	limits.MinSampleRate, limits.MaxSampleRate = 0, math.MaxFloat64
+	SetLimits(testGame, limits)
+}
+
+var testLines = []*runfile.Line{
+	{MapName: "d1_trainstation_01", PlayerName: "runner", Time: 0, X: -14576, Y: -13424, Z: -3160},
+	{MapName: "", PlayerName: "", Time: 0.5, X: -14575.5, Y: -13423.25, Z: -3160},
+	{MapName: "d1_trainstation_02", PlayerName: "runner", Time: 61.5, X: -5120, Y: -4608, Z: 12.03125},
+	{MapName: "d1_trainstation_02", PlayerName: "someone else", Time: 62, X: -5000, Y: -4608, Z: 12.03125},
+}
+
+// Converts seconds to a duration the same way as the analyzers, rounding and all.
+func seconds(s float32) time.Duration {
+	return time.Duration(s * float32(time.Second))
+}
+
+// Clears the movement and sample rates from a result so that the rest of it can be compared exactly. They're checked by their own tests.
+func withoutMeasurements(result *Result) *Result {
+	stripped := *result
+	stripped.Movement, stripped.SampleRate = Movement{}, 0
+	stripped.Maps = make([]Map, len(result.Maps))
+	for i, m := range result.Maps {
+		stripped.Maps[i] = Map{Name: m.Name, Time: m.Time, GameTime: m.GameTime}
+	}
+	return &stripped
+}
+
+// Writes a run and reads it back up to its first line.
+func readTestRun(t *testing.T, game byte, lines []*runfile.Line, chop int) (*runfile.Reader, *runfile.Header) {
+	buf := new(bytes.Buffer)
+	w := runfile.NewWriter(buf)
+	if err := w.WritePreamble(); err != nil {
+		t.Fatalf("Unable to write preamble: %s", err)
+	}
+	if err := w.WriteHeader(&runfile.Header{Game: game, TrailLength: 5}); err != nil {
+		t.Fatalf("Unable to write header: %s", err)
+	}
+	for i, line := range lines {
+		if err := w.WriteLine(line); err != nil {
+			t.Fatalf("Unable to write line #%d: %s", i, err)
+		}
+	}
+	buf.Truncate(buf.Len() - chop)
+
+	r := runfile.NewReader(buf)
+	if _, err := r.VerifyPreamble(); err != nil {
+		t.Fatalf("Unable to verify preamble: %s", err)
+	}
+	header, err := r.ReadHeader()
+	if err != nil {
+		t.Fatalf("Unable to read header: %s", err)
+	}
+	return r, header
+}
+
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+
+	result, err := Analyze(readTestRun(t, testGame, testLines, 0))
+	if err != nil {
+		t.Fatalf("Unable to analyze run: %s", err)
+	}
+
+	expected := &Result{
+		Maps: []Map{
+			{Name: "d1_trainstation_01", Time: seconds(61.5), GameTime: seconds(0.5)},
+			{Name: "d1_trainstation_02", Time: seconds(0.5), GameTime: seconds(0.5)},
+		},
+		Players: []string{"runner", "someone else"},
+		Legs: []Leg{
+			{Player: "runner", Start: 0, End: seconds(62), FirstMap: 0, LastMap: 1},
+			{Player: "someone else", Start: seconds(62), End: seconds(62), FirstMap: 1, LastMap: 1},
+		},
+		TotalTime: seconds(62),
+		GameTime:  seconds(1),
+		Lines:     4,
+		Segments:  1,
+	}
+	if !reflect.DeepEqual(withoutMeasurements(result), expected) {
+		t.Errorf("Expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestAnalyzeTruncated(t *testing.T) {
+	t.Parallel()
+
+	result, err := Analyze(readTestRun(t, testGame, testLines, 3))
+	if _, ok := err.(*runfile.DecodeError); !ok {
+		t.Fatalf("Expected a *runfile.DecodeError, got %#v", err)
+	}
+	if result == nil {
+		t.Fatalf("Expected a partial result")
+	}
+
+	expected := &Result{
+		Maps:      []Map{{Name: "d1_trainstation_01", Time: seconds(61.5), GameTime: seconds(0.5)}},
+		Players:   []string{"runner"},
+		Legs:      []Leg{{Player: "runner", Start: 0, End: seconds(61.5), FirstMap: 0, LastMap: 0}},
+		TotalTime: seconds(61.5),
+		GameTime:  seconds(0.5),
+		Lines:     3,
+		Segments:  1,
+	}
+	if !reflect.DeepEqual(withoutMeasurements(result), expected) {
+		t.Errorf("Expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestAnalyzeNoLines(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Analyze(readTestRun(t, testGame, nil, 0)); err != ErrNoLines {
+		t.Errorf("Expected ErrNoLines, got %v", err)
+	}
+}
+
+func TestMovement(t *testing.T) {
+	t.Parallel()
+
+	lines := []*runfile.Line{
+		{MapName: "d1_canals_01", Time: 0, X: 0, Y: 0, Z: 0},
+		{Time: 1, X: 300, Y: 400, Z: 0},
+		{Time: 2, X: 300, Y: 400, Z: 120},
+		{MapName: "d1_canals_01a", Time: 2.5, X: 9000, Y: 9000, Z: 9000}, // A new map, so the jump isn't movement.
+		{Time: 3, X: 9000, Y: 9400, Z: 9000},
+	}
+	result, err := Analyze(readTestRun(t, testGame, lines, 0))
+	if err != nil {
+		t.Fatalf("Unable to analyze run: %s", err)
+	}
+
+	expected := []struct {
+		name     string
+		actual   Movement
+		expected Movement
+	}{
+		{"run", result.Movement, Movement{Distance: 1020, AverageSpeed: 900.0 / 2.5, PeakSpeed: 800, VerticalTravel: 120}},
+		{"first map", result.Maps[0].Movement, Movement{Distance: 620, AverageSpeed: 250, PeakSpeed: 500, VerticalTravel: 120}},
+		{"second map", result.Maps[1].Movement, Movement{Distance: 400, AverageSpeed: 800, PeakSpeed: 800}},
+	}
+	for _, e := range expected {
+		if math.Abs(e.actual.Distance-e.expected.Distance) > 0.01 ||
+			math.Abs(e.actual.AverageSpeed-e.expected.AverageSpeed) > 0.01 ||
+			math.Abs(e.actual.PeakSpeed-e.expected.PeakSpeed) > 0.01 ||
+			math.Abs(e.actual.VerticalTravel-e.expected.VerticalTravel) > 0.01 {
+			t.Errorf("Expected the %s's movement to be %+v, got %+v", e.name, e.expected, e.actual)
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	t.Parallel()
+
+	lines := []*runfile.Line{
+		{MapName: "d1_canals_01", Time: 0, X: 0, Y: 0, Z: 0},
+		{Time: 1, X: 300, Y: 0, Z: 0},
+		{Time: 1.1, X: 300, Y: 2000, Z: 0}, // A teleport.
+		{Time: 1.2, X: 300, Y: 2600, Z: 0}, // Too fast, twice in a row.
+		{Time: 1.3, X: 300, Y: 3300, Z: 0},
+		{Time: 1.4, X: 300, Y: 3300, Z: 300},                             // Flying.
+		{MapName: "d1_canals_01a", Time: 1.5, X: 9000, Y: 9000, Z: 9000}, // A new map, so the jump is fine.
+	}
+	result, err := Analyze(readTestRun(t, testGame, lines, 0))
+	if err != nil {
+		t.Fatalf("Unable to analyze run: %s", err)
+	}
+
+	expected := []struct {
+		kind  string
+		line  int
+		value float64
+	}{
+		{FlagTeleport, 3, 2000},
+		{FlagSpeed, 4, 7000},
+		{FlagFlying, 6, 3000},
+	}
+	if len(result.Flags) != len(expected) {
+		t.Fatalf("Expected %d flags, got %+v", len(expected), result.Flags)
+	}
+	for i, e := range expected {
+		flag := result.Flags[i]
+		if flag.Kind != e.kind || flag.Line != e.line || math.Abs(flag.Value-e.value) > 1 {
+			t.Errorf("Expected flag #%d to be a %s flag on line %d with %.0f, got %+v", i, e.kind, e.line, e.value, flag)
+		}
+	}
+}
+
+func TestSanityFlags(t *testing.T) {
+	t.Parallel()
+
+	lines := []*runfile.Line{
+		{MapName: "d1_canals_01", Time: 0},
+		{Time: 0.1},
+		{Time: 0.05},                         // Backwards.
+		{Time: 2.05},                         // A gap.
+		{MapName: "d1_canals_01a", Time: 10}, // A gap while loading, which is fine.
+		{Time: 10.1},
+	}
+	result, err := Analyze(readTestRun(t, testGame, lines, 0))
+	if err != nil {
+		t.Fatalf("Unable to analyze run: %s", err)
+	}
+
+	if len(result.Flags) != 2 {
+		t.Fatalf("Expected 2 flags, got %+v", result.Flags)
+	}
+	if flag := result.Flags[0]; flag.Kind != FlagTimeBackwards || flag.Line != 3 {
+		t.Errorf("Expected time to go backwards on line 3, got %+v", flag)
+	}
+	if flag := result.Flags[1]; flag.Kind != FlagGap || flag.Line != 4 || math.Abs(flag.Value-2) > 0.001 {
+		t.Errorf("Expected a 2 second gap on line 4, got %+v", flag)
+	}
+}
+
+func TestNotFinite(t *testing.T) {
+	t.Parallel()
+
+	lines := []*runfile.Line{
+		{MapName: "d1_canals_01", Time: 0},
+		{MapName: "d1_canals_01a", Time: 1},
+		{Time: 2, X: float32(math.NaN())},
+		{Time: 3},
+	}
+	result, err := Analyze(readTestRun(t, testGame, lines, 0))
+	decodeErr, ok := err.(*runfile.DecodeError)
+	if !ok || decodeErr.Err != ErrNotFinite || decodeErr.Line != 3 {
+		t.Fatalf("Expected ErrNotFinite on line 3, got %#v", err)
+	}
+	if result == nil || len(result.Maps) != 1 || result.Lines != 2 {
+		t.Errorf("Expected the first map to be kept, got %#v", result)
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	t.Parallel()
+
+	lines := []*runfile.Line{{MapName: "d1_canals_01", Time: 0}}
+	addLines := func(mapName string, rate float32, seconds int) {
+		start := lines[len(lines)-1].Time
+		for i := 1; i <= int(rate)*seconds; i++ {
+			lines = append(lines, &runfile.Line{MapName: mapName, Time: start + float32(i)/rate})
+		}
+	}
+	addLines("", 66, 2)
+	addLines("", 250, 2)               // Too sudden a change.
+	addLines("d1_canals_01a", 2000, 1) // Too fast for Half-Life 2.
+
+	result, err := Analyze(readTestRun(t, 0x00, lines, 0))
+	if err != nil {
+		t.Fatalf("Unable to analyze run: %s", err)
+	}
+
+	if len(result.Maps) != 2 || math.Abs(result.Maps[1].SampleRate-2000) > 1 {
+		t.Errorf("Expected the second map to be recorded at 2000 lines a second, got %+v", result.Maps)
+	}
+	if len(result.Flags) != 2 || result.Flags[0].Kind != FlagRateChange || result.Flags[1].Kind != FlagSampleRate {
+		t.Fatalf("Expected a rate change and a sample rate flag, got %+v", result.Flags)
+	}
+	if math.Abs(result.Flags[0].Value-250) > 1 {
+		t.Errorf("Expected the rate to change to 250 lines a second, got %+v", result.Flags[0])
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	lines := []*runfile.Line{
+		{MapName: "d1_canals_01", Time: 0},
+		{Time: 0.1, X: 10},
+		{Time: 3, X: 500}, // A save was loaded.
+		{Time: 3.1, X: 510},
+		{MapName: "d1_canals_01a", Time: 4, X: 9000},
+		{Time: 4.1, X: 9010},
+		{MapName: "d1_canals_01", Time: 5, X: 500}, // Back to a map that was already played.
+		{Time: 5.1, X: 510},
+		{Time: 0, X: 0}, // A new segment.
+		{Time: 0.1, X: 10},
+		{Time: 3, X: 10}, // A pause, which isn't a load.
+	}
+	result, err := Analyze(readTestRun(t, testGame, lines, 0))
+	if err != nil {
+		t.Fatalf("Unable to analyze run: %s", err)
+	}
+
+	if result.Loads != 3 || result.Segments != 2 || !result.Segmented() {
+		t.Errorf("Expected 3 loads in 2 segments, got %d loads in %d segments", result.Loads, result.Segments)
+	}
+	if len(result.Maps) != 3 || result.Maps[0].Loads != 1 || result.Maps[1].Loads != 0 || result.Maps[2].Loads != 2 {
+		t.Errorf("Expected 1, 0 and 2 loads in the maps, got %+v", result.Maps)
+	}
+	if len(result.Flags) != 1 || result.Flags[0].Kind != FlagGap || result.Flags[0].Line != 11 {
+		t.Errorf("Expected only the pause to be flagged, got %+v", result.Flags)
+	}
+}
+
+func TestSegmentedTime(t *testing.T) {
+	t.Parallel()
+
+	lines := []*runfile.Line{
+		{MapName: "d1_canals_01", Time: 0},
+		{Time: 0.5},
+		{Time: 10},
+		{Time: 2}, // A new segment, loaded from a save made 2 seconds into the map.
+		{Time: 2.5},
+		{MapName: "d1_canals_01a", PlayerName: "second", Time: 6},
+		{Time: 8},
+	}
+	result, err := Analyze(readTestRun(t, testGame, lines, 0))
+	if err != nil {
+		t.Fatalf("Unable to analyze run: %s", err)
+	}
+
+	// The first segment took 10 seconds and the second took 6.
+	if expected := seconds(16); result.TotalTime != expected {
+		t.Errorf("Expected a real time of %s, got %s", expected, result.TotalTime)
+	}
+	if len(result.Maps) != 2 || result.Maps[0].Time != seconds(14) || result.Maps[1].Time != seconds(2) {
+		t.Errorf("Expected the maps to take 14s and 2s, got %+v", result.Maps)
+	}
+	if len(result.Legs) != 2 || result.Legs[0].End != seconds(14) || result.Legs[1].Start != seconds(14) || result.Legs[1].End != result.TotalTime {
+		t.Errorf("Expected the legs to split the run at 14s, got %+v", result.Legs)
+	}
+}
+
+func TestIdle(t *testing.T) {
+	t.Parallel()
+
+	lines := []*runfile.Line{{MapName: "d1_canals_01", Time: 0}}
+	addLines := func(seconds int, speed float32) {
+		last := lines[len(lines)-1]
+		for i := 1; i <= seconds*10; i++ {
+			lines = append(lines, &runfile.Line{Time: last.Time + float32(i)/10, X: last.X + speed*float32(i)/10})
+		}
+	}
+	addLines(10, 0)  // Waiting at the start.
+	addLines(3, 200) // Running.
+	addLines(2, 0)   // Too short a wait.
+	addLines(3, 200)
+	addLines(6, 0) // Waiting after finishing.
+
+	result, err := Analyze(readTestRun(t, testGame, lines, 0))
+	if err != nil {
+		t.Fatalf("Unable to analyze run: %s", err)
+	}
+
+	leading, trailing := result.LeadingIdle(), result.TrailingIdle()
+	if len(result.Idle) != 2 || leading == nil || trailing == nil {
+		t.Fatalf("Expected the run to start and end idle, got %+v", result.Idle)
+	}
+	if leading.EndLine != 101 || math.Abs(leading.Duration().Seconds()-10) > 0.01 {
+		t.Errorf("Expected the run to start with 10 idle seconds, got %+v", leading)
+	}
+	if trailing.StartLine != 181 || math.Abs(trailing.Duration().Seconds()-6) > 0.01 {
+		t.Errorf("Expected the run to end with 6 idle seconds, got %+v", trailing)
+	}
+}
+
+func TestLegs(t *testing.T) {
+	t.Parallel()
+
+	lines := []*runfile.Line{
+		{MapName: "d1_canals_01", PlayerName: "first", Time: 0},
+		{Time: 10},
+		{MapName: "d1_canals_01a", PlayerName: "second", Time: 20},
+		{MapName: "d1_canals_02", Time: 30},
+		{PlayerName: "first", Time: 40},
+		{Time: 50},
+	}
+
+	result, err := Analyze(readTestRun(t, testGame, lines, 0))
+	if err != nil {
+		t.Fatalf("Unable to analyze run: %s", err)
+	}
+
+	expected := []Leg{
+		{Player: "first", Start: 0, End: seconds(20), FirstMap: 0, LastMap: 0},
+		{Player: "second", Start: seconds(20), End: seconds(40), FirstMap: 1, LastMap: 2},
+		{Player: "first", Start: seconds(40), End: seconds(50), FirstMap: 2, LastMap: 2},
+	}
+	if !reflect.DeepEqual(result.Legs, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, result.Legs)
+	}
+	if !reflect.DeepEqual(result.Players, []string{"first", "second"}) {
+		t.Errorf("Expected first and second to have played, got %q", result.Players)
+	}
+}
+
+func TestLegLimit(t *testing.T) {
+	t.Parallel()
+
+	lines := make([]*runfile.Line, maxLegs+10)
+	for i := range lines {
+		lines[i] = &runfile.Line{PlayerName: fmt.Sprintf("runner %d", i), Time: float32(i)}
+	}
+	lines[0].MapName = "d1_canals_01"
+
+	result, err := Analyze(readTestRun(t, testGame, lines, 0))
+	if err != nil {
+		t.Fatalf("Unable to analyze run: %s", err)
+	}
+
+	if len(result.Legs) != maxLegs || len(result.Players) != maxLegs {
+		t.Fatalf("Expected %d legs and players, got %d legs and %d players", maxLegs, len(result.Legs), len(result.Players))
+	}
+	if last := result.Legs[maxLegs-1]; last.Player != lines[maxLegs-1].PlayerName || last.End != result.TotalTime {
+		t.Errorf("Expected the last leg to run to the end, got %+v", last)
+	}
+}
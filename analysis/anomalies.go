@@ -0,0 +1,99 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"math"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// Limits on what a player can do in a game. Going past them gets a run flagged.
+// Speeds are in units per second and distances are in units.
+type Limits struct {
+	MaxSpeed     float64 // Horizontal speed.
+	MaxTeleport  float64 // How far a player can move between two lines faster than MaxSpeed before it's a teleport.
+	MaxRiseSpeed float64 // Vertical speed upwards.
+
+	// How many lines a second a map can be recorded at. This follows the frame rate, so it catches abuse of fps_max.
+	MinSampleRate float64
+	MaxSampleRate float64
+}
+
+var gameLimits = map[byte]Limits{
+	0x00: { // Half-Life 2
+		MaxSpeed:     4950, // sv_maxvelocity caps each axis at 3500.
+		MaxTeleport:  1000,
+		MaxRiseSpeed: 1500, // Well above jumping, ladders and lifts, but explosions can still throw players this fast.
+
+		MinSampleRate: 20,
+		MaxSampleRate: 1000,
+	},
+}
+
+// Sets the limits used to flag runs of a game.
+// This should only be called during initialization.
+func SetLimits(game byte, limits Limits) {
+	gameLimits[game] = limits
+}
+
+// Returns the limits for a game. Runs of games without limits are never flagged.
+func LimitsFor(game byte) (Limits, bool) {
+	limits, ok := gameLimits[game]
+	return limits, ok
+}
+
+// Flags the movement between lines that's beyond the game's limits.
+// Moving to a new map usually puts the player somewhere else entirely, so that movement isn't checked.
+type anomalyAnalyzer struct {
+	flagList
+
+	limits  Limits
+	checked bool
+
+	started bool
+	line    int
+	mapName string
+	last    runfile.Line
+}
+
+func newAnomalyAnalyzer() Analyzer {
+	return new(anomalyAnalyzer)
+}
+
+func (a *anomalyAnalyzer) Begin(header *runfile.Header) {
+	a.limits, a.checked = LimitsFor(header.Game)
+}
+
+func (a *anomalyAnalyzer) Line(line *runfile.Line) {
+	a.line++
+	a.clock.advance(line.Time)
+	defer func() { a.last = *line }()
+
+	if !a.started || (len(line.MapName) > 0 && a.mapName != line.MapName) {
+		a.started, a.mapName = true, line.MapName
+		return
+	}
+
+	dt := float64(line.Time - a.last.Time)
+	if !a.checked || dt <= 0 || isReload(&a.last, line, dt) {
+		return
+	}
+	dx, dy, dz := float64(line.X-a.last.X), float64(line.Y-a.last.Y), float64(line.Z-a.last.Z)
+
+	distance, speed := math.Sqrt(dx*dx+dy*dy+dz*dz), math.Hypot(dx, dy)/dt
+	switch {
+	case distance > a.limits.MaxTeleport && distance/dt > a.limits.MaxSpeed:
+		a.add(FlagTeleport, a.line, a.clock.now(), line, distance)
+	case speed > a.limits.MaxSpeed:
+		a.add(FlagSpeed, a.line, a.clock.now(), line, speed)
+	case dz/dt > a.limits.MaxRiseSpeed:
+		a.add(FlagFlying, a.line, a.clock.now(), line, dz/dt)
+	}
+}
+
+func (a *anomalyAnalyzer) End(result *Result, complete bool) {
+	result.Flags = append(result.Flags, a.flags...)
+}
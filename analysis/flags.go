@@ -0,0 +1,100 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// The kinds of flags.
+const (
+	FlagSpeed    = "speed"    // The player moved faster than the game allows.
+	FlagTeleport = "teleport" // The player jumped somewhere else without changing maps.
+	FlagFlying   = "flying"   // The player rose faster than they can without cheating, such as with noclip.
+
+	FlagTimeBackwards = "time_backwards" // A line's time is earlier than the line before it.
+	FlagGap           = "gap"            // Nothing was recorded for a while in the middle of a map.
+	FlagSampleRate    = "sample_rate"    // A map was recorded at a rate outside the game's limits.
+	FlagRateChange    = "rate_change"    // The sample rate suddenly changed.
+)
+
+// Something implausible that happened during a run, which a moderator should look at before the run is ranked.
+// Flags are only warnings. Problems that make a run impossible to time fail its analysis instead.
+// Flags for consecutive lines are merged into the first, with Value being the worst of them.
+type Flag struct {
+	Kind  string        `datastore:",noindex" json:"kind"`
+	Line  int           `datastore:",noindex" json:"line"` // The number of the line, counting from 1.
+	Time  time.Duration `datastore:",noindex" json:"time"`
+	X     float32       `datastore:",noindex" json:"x"`
+	Y     float32       `datastore:",noindex" json:"y"`
+	Z     float32       `datastore:",noindex" json:"z"`
+	Value float64       `datastore:",noindex" json:"value"` // The speed, distance or number of seconds that broke the limit.
+}
+
+// How many flags an analyzer can raise. A run with this many is suspicious enough already.
+const maxFlags = 100
+
+// Collects the flags raised by an analyzer, merging flags of the same kind for consecutive lines.
+// The analyzer advances the clock with every line, so that flags are given the run's real time.
+type flagList struct {
+	flags []Flag
+	clock runClock
+
+	lastFlaggedLine int
+}
+
+func (l *flagList) add(kind string, lineNumber int, at time.Duration, line *runfile.Line, value float64) {
+	defer func() { l.lastFlaggedLine = lineNumber }()
+
+	if n := len(l.flags); n > 0 && l.flags[n-1].Kind == kind && l.lastFlaggedLine == lineNumber-1 {
+		l.flags[n-1].Value = math.Max(l.flags[n-1].Value, value)
+		return
+	}
+	if len(l.flags) >= maxFlags {
+		return
+	}
+
+	l.flags = append(l.flags, Flag{
+		Kind:  kind,
+		Line:  lineNumber,
+		Time:  at,
+		X:     line.X,
+		Y:     line.Y,
+		Z:     line.Z,
+		Value: value,
+	})
+}
+
+// Orders flags by the line they were raised for.
+type flagsByLine []Flag
+
+func (f flagsByLine) Len() int           { return len(f) }
+func (f flagsByLine) Less(i, j int) bool { return f[i].Line < f[j].Line }
+func (f flagsByLine) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// Describes the flag for people.
+func (f Flag) String() string {
+	switch f.Kind {
+	case FlagSpeed:
+		return fmt.Sprintf("Moved at %.0f u/s", f.Value)
+	case FlagTeleport:
+		return fmt.Sprintf("Jumped %.0f units", f.Value)
+	case FlagFlying:
+		return fmt.Sprintf("Rose at %.0f u/s", f.Value)
+	case FlagTimeBackwards:
+		return fmt.Sprintf("Time went back %.3f seconds", f.Value)
+	case FlagGap:
+		return fmt.Sprintf("Nothing was recorded for %.1f seconds", f.Value)
+	case FlagSampleRate:
+		return fmt.Sprintf("The map was recorded at %.0f lines a second", f.Value)
+	case FlagRateChange:
+		return fmt.Sprintf("The recording rate changed to %.0f lines a second", f.Value)
+	}
+	return fmt.Sprintf("%s (%.0f)", f.Kind, f.Value)
+}
@@ -0,0 +1,93 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+const (
+	minIdle       = 5.0 // How many seconds the player has to stand still for before they're idle.
+	idleTolerance = 1.0 // How far the player can drift, in units, while standing still.
+	maxIdles      = 100
+)
+
+// A stretch of a run during which the player didn't move, such as waiting at the menu before starting.
+type Idle struct {
+	StartLine int           `datastore:",noindex" json:"start_line"` // The numbers of the first and last lines of the stretch, counting from 1.
+	EndLine   int           `datastore:",noindex" json:"end_line"`
+	Start     time.Duration `datastore:",noindex" json:"start"`
+	End       time.Duration `datastore:",noindex" json:"end"`
+}
+
+func (i Idle) Duration() time.Duration {
+	return i.End - i.Start
+}
+
+// Returns the idle stretch that the run starts with, or nil if it doesn't start idle.
+func (r *Result) LeadingIdle() *Idle {
+	if len(r.Idle) > 0 && r.Idle[0].StartLine == 1 {
+		return &r.Idle[0]
+	}
+	return nil
+}
+
+// Returns the idle stretch that the run ends with, or nil if it doesn't end idle.
+func (r *Result) TrailingIdle() *Idle {
+	if n := len(r.Idle); n > 0 && r.Idle[n-1].EndLine == r.Lines {
+		return &r.Idle[n-1]
+	}
+	return nil
+}
+
+// Finds the stretches of a run during which the player stood still. A stretch ends when the player moves or changes maps.
+type idleAnalyzer struct {
+	idles []Idle
+
+	clock   runClock
+	started bool
+	line    int
+	mapName string
+	current Idle
+	start   runfile.Line
+}
+
+func newIdleAnalyzer() Analyzer {
+	return new(idleAnalyzer)
+}
+
+func (a *idleAnalyzer) Begin(header *runfile.Header) {}
+
+func (a *idleAnalyzer) Line(line *runfile.Line) {
+	a.line++
+	a.clock.advance(line.Time)
+
+	newMap := !a.started || (len(line.MapName) > 0 && a.mapName != line.MapName)
+	if newMap {
+		a.started, a.mapName = true, line.MapName
+	}
+
+	dx, dy, dz := line.X-a.start.X, line.Y-a.start.Y, line.Z-a.start.Z
+	if newMap || dx*dx+dy*dy+dz*dz > idleTolerance*idleTolerance {
+		a.finish()
+		a.current = Idle{StartLine: a.line, Start: a.clock.now()}
+		a.start = *line
+	}
+	a.current.EndLine, a.current.End = a.line, a.clock.now()
+}
+
+// Keeps the current stretch if the player stood still for long enough.
+func (a *idleAnalyzer) finish() {
+	if a.current.EndLine > 0 && a.current.Duration().Seconds() >= minIdle && len(a.idles) < maxIdles {
+		a.idles = append(a.idles, a.current)
+	}
+}
+
+func (a *idleAnalyzer) End(result *Result, complete bool) {
+	a.finish()
+	result.Idle = a.idles
+}
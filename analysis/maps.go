@@ -0,0 +1,46 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// Splits a run into the maps that were played, timing each from its first line to the first line of the next map.
+type mapAnalyzer struct {
+	maps []Map
+
+	clock        runClock
+	current      Map
+	currentStart float32
+	startOffset  float64
+}
+
+func newMapAnalyzer() Analyzer {
+	return &mapAnalyzer{maps: make([]Map, 0)}
+}
+
+func (a *mapAnalyzer) Begin(header *runfile.Header) {}
+
+func (a *mapAnalyzer) Line(line *runfile.Line) {
+	started := a.clock.started
+	a.clock.advance(line.Time)
+	if !started {
+		a.current, a.currentStart, a.startOffset = Map{Name: line.MapName}, line.Time, a.clock.offset
+	} else if len(line.MapName) > 0 && a.current.Name != line.MapName {
+		a.current.Time = a.clock.since(a.currentStart, a.startOffset)
+		a.maps = append(a.maps, a.current)
+
+		a.current, a.currentStart, a.startOffset = Map{Name: line.MapName}, line.Time, a.clock.offset
+	}
+}
+
+func (a *mapAnalyzer) End(result *Result, complete bool) {
+	if complete && a.clock.started {
+		a.current.Time = a.clock.since(a.currentStart, a.startOffset)
+		a.maps = append(a.maps, a.current) // Insert the last map
+	}
+	result.Maps = a.maps
+}
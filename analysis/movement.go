@@ -0,0 +1,93 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"math"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// How much a player moved. Distances are in game units and speeds are in units per second.
+type Movement struct {
+	Distance       float64 `datastore:",noindex" json:"distance"`        // Over all three axes.
+	AverageSpeed   float64 `datastore:",noindex" json:"average_speed"`   // Horizontal speed, which is what bunny hopping gains.
+	PeakSpeed      float64 `datastore:",noindex" json:"peak_speed"`      // The fastest horizontal speed between two lines.
+	VerticalTravel float64 `datastore:",noindex" json:"vertical_travel"` // Climbing and falling added together.
+}
+
+// Adds up the movement between lines.
+type movementTotal struct {
+	Movement
+
+	horizontal float64
+	duration   float64
+}
+
+func (t *movementTotal) add(dx, dy, dz, dt float64) {
+	horizontal := math.Hypot(dx, dy)
+
+	t.Distance += math.Sqrt(dx*dx + dy*dy + dz*dz)
+	t.VerticalTravel += math.Abs(dz)
+	t.horizontal += horizontal
+
+	if dt > 0 { // Time can go backwards in a broken run.
+		t.duration += dt
+		t.PeakSpeed = math.Max(t.PeakSpeed, horizontal/dt)
+	}
+}
+
+func (t *movementTotal) movement() Movement {
+	m := t.Movement
+	if t.duration > 0 {
+		m.AverageSpeed = t.horizontal / t.duration
+	}
+	return m
+}
+
+// Works out how the player moved over each map and over the whole run.
+// Moving to a new map usually puts the player somewhere else entirely, so the movement between two maps isn't counted.
+// Neither is the movement from loading a save.
+type movementAnalyzer struct {
+	run  movementTotal
+	maps []movementTotal
+
+	started bool
+	mapName string
+	last    runfile.Line
+}
+
+func newMovementAnalyzer() Analyzer {
+	return new(movementAnalyzer)
+}
+
+func (a *movementAnalyzer) Begin(header *runfile.Header) {}
+
+func (a *movementAnalyzer) Line(line *runfile.Line) {
+	switch {
+	case !a.started:
+		a.started, a.mapName = true, line.MapName
+		a.maps = append(a.maps, movementTotal{})
+	case len(line.MapName) > 0 && a.mapName != line.MapName:
+		a.mapName = line.MapName
+		a.maps = append(a.maps, movementTotal{})
+	case isTimeReset(float64(line.Time-a.last.Time)) || isReload(&a.last, line, float64(line.Time-a.last.Time)):
+		// Loading a save moves the player without them moving.
+	default:
+		dx, dy, dz := float64(line.X-a.last.X), float64(line.Y-a.last.Y), float64(line.Z-a.last.Z)
+		dt := float64(line.Time - a.last.Time)
+
+		a.run.add(dx, dy, dz, dt)
+		a.maps[len(a.maps)-1].add(dx, dy, dz, dt)
+	}
+	a.last = *line
+}
+
+func (a *movementAnalyzer) End(result *Result, complete bool) {
+	result.Movement = a.run.movement()
+	for i := range result.Maps {
+		result.Maps[i].Movement = a.maps[i].movement()
+	}
+}
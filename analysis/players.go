@@ -0,0 +1,114 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// A stretch of a run played by one player, from the line where they took over to the line where the next player did.
+// A relay run credits each of its legs to the runner that played it.
+type Leg struct {
+	Player   string        `datastore:",noindex" json:"runner"`
+	Start    time.Duration `datastore:",noindex" json:"start"`
+	End      time.Duration `datastore:",noindex" json:"end"`
+	FirstMap int           `datastore:",noindex" json:"first_map"` // The indexes in Maps of the maps the leg started and ended on.
+	LastMap  int           `datastore:",noindex" json:"last_map"`
+}
+
+func (l Leg) Duration() time.Duration {
+	return l.End - l.Start
+}
+
+// How many legs a run is split into. Players that take over after this are part of the last leg, and aren't listed if they didn't play before.
+const maxLegs = 100
+
+// Lists everybody that played during a run, in the order they first played, and splits the run into the legs that each of them played.
+type playerAnalyzer struct {
+	players []string
+	legs    []Leg
+
+	started    bool
+	lastPlayer string
+	current    Leg
+
+	// Maps are followed the same way as mapAnalyzer, so that the legs can point at them.
+	clock    runClock
+	mapName  string
+	mapIndex int
+	mapStart time.Duration
+}
+
+func newPlayerAnalyzer() Analyzer {
+	return new(playerAnalyzer)
+}
+
+func (a *playerAnalyzer) Begin(header *runfile.Header) {}
+
+func (a *playerAnalyzer) Line(line *runfile.Line) {
+	a.clock.advance(line.Time)
+	now := a.clock.now()
+
+	if !a.started {
+		a.started = true
+		a.players, a.lastPlayer = []string{line.PlayerName}, line.PlayerName
+		a.mapName, a.mapStart = line.MapName, now
+		a.current = Leg{Player: line.PlayerName, Start: now}
+		return
+	}
+
+	if len(line.MapName) > 0 && a.mapName != line.MapName {
+		a.mapName, a.mapIndex, a.mapStart = line.MapName, a.mapIndex+1, now
+	}
+
+	if len(line.PlayerName) > 0 && a.lastPlayer != line.PlayerName && len(a.legs) < maxLegs-1 {
+		found := false
+		for _, name := range a.players {
+			if line.PlayerName == name {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			a.players = append(a.players, line.PlayerName)
+		}
+
+		a.lastPlayer = line.PlayerName
+
+		a.current.End = now
+		a.legs = append(a.legs, a.current)
+		a.current = Leg{Player: line.PlayerName, Start: a.current.End, FirstMap: a.mapIndex}
+	}
+	a.current.LastMap = a.mapIndex
+}
+
+func (a *playerAnalyzer) End(result *Result, complete bool) {
+	result.Players = a.players
+	if !a.started {
+		return
+	}
+
+	a.current.End = a.clock.now()
+	legs := append(a.legs, a.current)
+	if !complete {
+		// Only the maps that were finished are kept, so the legs stop where the unfinished map started.
+		cutoff := a.mapStart
+		kept := make([]Leg, 0, len(legs))
+		for _, leg := range legs {
+			if leg.FirstMap >= a.mapIndex {
+				break
+			}
+			if leg.LastMap >= a.mapIndex {
+				leg.LastMap, leg.End = a.mapIndex-1, cutoff
+			}
+			kept = append(kept, leg)
+		}
+		legs = kept
+	}
+	result.Legs = legs
+}
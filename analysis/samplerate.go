@@ -0,0 +1,122 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+const (
+	sampleWindow  = 1.0 // How many seconds the sample rate is measured over when looking for changes.
+	maxRateChange = 2.0 // How many times faster or slower the sample rate can get from one window to the next.
+)
+
+// Counts lines to work out how many are recorded a second, which follows the player's frame rate.
+// Gaps and time going backwards aren't counted.
+type sampleCounter struct {
+	samples  int
+	duration float64
+}
+
+func (s *sampleCounter) add(dt float64) {
+	if dt > 0 && dt <= maxSampleGap {
+		s.samples++
+		s.duration += dt
+	}
+}
+
+func (s *sampleCounter) rate() float64 {
+	if s.duration == 0 {
+		return 0
+	}
+	return float64(s.samples) / s.duration
+}
+
+// Works out the sample rate of each map and of the whole run. It flags maps whose rate is outside the game's limits,
+// and places where the rate suddenly changes, which can mean that the player changed fps_max.
+type sampleRateAnalyzer struct {
+	flagList
+
+	limits  Limits
+	checked bool
+
+	run  sampleCounter
+	maps []sampleCounter
+
+	window         sampleCounter
+	lastWindowRate float64
+
+	started      bool
+	line         int
+	mapName      string
+	mapStartLine int
+	mapStartTime time.Duration
+	mapStart     runfile.Line
+	lastTime     float32
+}
+
+func newSampleRateAnalyzer() Analyzer {
+	return new(sampleRateAnalyzer)
+}
+
+func (a *sampleRateAnalyzer) Begin(header *runfile.Header) {
+	a.limits, a.checked = LimitsFor(header.Game)
+}
+
+func (a *sampleRateAnalyzer) Line(line *runfile.Line) {
+	a.line++
+	a.clock.advance(line.Time)
+	defer func() { a.lastTime = line.Time }()
+
+	if !a.started || (len(line.MapName) > 0 && a.mapName != line.MapName) {
+		if a.started {
+			a.checkMap()
+		}
+		a.started, a.mapName = true, line.MapName
+		a.mapStartLine, a.mapStartTime, a.mapStart = a.line, a.clock.now(), *line
+		a.maps = append(a.maps, sampleCounter{})
+		a.window, a.lastWindowRate = sampleCounter{}, 0
+		return
+	}
+
+	dt := float64(line.Time - a.lastTime)
+	a.run.add(dt)
+	a.maps[len(a.maps)-1].add(dt)
+
+	a.window.add(dt)
+	if a.window.duration >= sampleWindow {
+		rate := a.window.rate()
+		if a.lastWindowRate > 0 && (rate > a.lastWindowRate*maxRateChange || rate < a.lastWindowRate/maxRateChange) {
+			a.add(FlagRateChange, a.line, a.clock.now(), line, rate)
+		}
+		a.window, a.lastWindowRate = sampleCounter{}, rate
+	}
+}
+
+// Flags the current map if its sample rate is outside the game's limits.
+func (a *sampleRateAnalyzer) checkMap() {
+	current := a.maps[len(a.maps)-1]
+	if !a.checked || current.samples == 0 {
+		return
+	}
+
+	if rate := current.rate(); rate < a.limits.MinSampleRate || rate > a.limits.MaxSampleRate {
+		a.add(FlagSampleRate, a.mapStartLine, a.mapStartTime, &a.mapStart, rate)
+	}
+}
+
+func (a *sampleRateAnalyzer) End(result *Result, complete bool) {
+	if a.started {
+		a.checkMap()
+	}
+
+	result.SampleRate = a.run.rate()
+	for i := range result.Maps {
+		result.Maps[i].SampleRate = a.maps[i].rate()
+	}
+	result.Flags = append(result.Flags, a.flags...)
+}
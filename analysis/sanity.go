@@ -0,0 +1,73 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"errors"
+	"math"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// Returned in a *runfile.DecodeError for a line whose time or position is NaN or infinite. Nothing after it can be timed.
+var ErrNotFinite = errors.New("a line's time or position isn't a finite number")
+
+// The longest that nothing can be recorded in the middle of a map, in seconds. Loading a map can take longer, so gaps at map changes are fine.
+const maxSampleGap = 1.0
+
+func isFinite(f float32) bool {
+	return !math.IsNaN(float64(f)) && !math.IsInf(float64(f), 0)
+}
+
+// Reports whether every number in a line is finite.
+func lineIsFinite(line *runfile.Line) bool {
+	return isFinite(line.Time) && isFinite(line.X) && isFinite(line.Y) && isFinite(line.Z)
+}
+
+// Flags lines whose time goes backwards, and gaps in the middle of a map.
+// These can throw off the map times, but a run with them can still be timed, so they're only warnings.
+// Time going back to start a new segment and gaps from loading a save are counted by the save/load analysis instead.
+type sanityAnalyzer struct {
+	flagList
+
+	started bool
+	line    int
+	mapName string
+	last    runfile.Line
+}
+
+func newSanityAnalyzer() Analyzer {
+	return new(sanityAnalyzer)
+}
+
+func (a *sanityAnalyzer) Begin(header *runfile.Header) {}
+
+func (a *sanityAnalyzer) Line(line *runfile.Line) {
+	a.line++
+	a.clock.advance(line.Time)
+	defer func() { a.last = *line }()
+
+	newMap := !a.started || (len(line.MapName) > 0 && a.mapName != line.MapName)
+	if newMap {
+		a.mapName = line.MapName
+	}
+	if !a.started {
+		a.started = true
+		return
+	}
+
+	dt := float64(line.Time - a.last.Time)
+	switch {
+	case isTimeReset(dt):
+	case dt < 0:
+		a.add(FlagTimeBackwards, a.line, a.clock.now(), line, -dt)
+	case dt > maxSampleGap && !newMap && !isReload(&a.last, line, dt):
+		a.add(FlagGap, a.line, a.clock.now(), line, dt)
+	}
+}
+
+func (a *sanityAnalyzer) End(result *Result, complete bool) {
+	result.Flags = append(result.Flags, a.flags...)
+}
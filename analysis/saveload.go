@@ -0,0 +1,95 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"math"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// How far a player has to have moved over a gap for it to be a save being loaded rather than the game pausing.
+const loadSnapDistance = 64.0
+
+// Reports whether time went back far enough between two lines for a new segment to have started.
+// Smaller steps back are glitches in the recording.
+func isTimeReset(dt float64) bool {
+	return dt < -maxSampleGap
+}
+
+// Reports whether the time between two lines of the same map looks like a save being loaded,
+// which is a gap in the recording after which the player is somewhere else.
+func isReload(last, line *runfile.Line, dt float64) bool {
+	if dt <= maxSampleGap {
+		return false
+	}
+
+	dx, dy, dz := float64(line.X-last.X), float64(line.Y-last.Y), float64(line.Z-last.Z)
+	return math.Sqrt(dx*dx+dy*dy+dz*dz) > loadSnapDistance
+}
+
+// Counts the saves that were loaded during a run, and how many segments it was played in.
+// A new segment starts whenever time is reset. Within a segment, a save was loaded if the player snaps somewhere
+// else after a gap, or if they go back to a map that they've already played.
+type saveLoadAnalyzer struct {
+	loads    int
+	segments int
+	maps     []int
+
+	visited map[string]bool
+	started bool
+	mapName string
+	last    runfile.Line
+}
+
+func newSaveLoadAnalyzer() Analyzer {
+	return &saveLoadAnalyzer{
+		segments: 1,
+		visited:  make(map[string]bool),
+	}
+}
+
+func (a *saveLoadAnalyzer) Begin(header *runfile.Header) {}
+
+func (a *saveLoadAnalyzer) Line(line *runfile.Line) {
+	defer func() { a.last = *line }()
+
+	if !a.started {
+		a.started, a.mapName = true, line.MapName
+		a.visited[line.MapName] = true
+		a.maps = append(a.maps, 0)
+		return
+	}
+
+	load := false
+	dt := float64(line.Time - a.last.Time)
+	if isTimeReset(dt) {
+		a.segments++
+		load = true
+	}
+
+	if len(line.MapName) > 0 && a.mapName != line.MapName {
+		a.mapName = line.MapName
+		a.maps = append(a.maps, 0)
+		if a.visited[line.MapName] {
+			load = true
+		}
+		a.visited[line.MapName] = true
+	} else if isReload(&a.last, line, dt) {
+		load = true
+	}
+
+	if load {
+		a.loads++
+		a.maps[len(a.maps)-1]++
+	}
+}
+
+func (a *saveLoadAnalyzer) End(result *Result, complete bool) {
+	result.Loads, result.Segments = a.loads, a.segments
+	for i := range result.Maps {
+		result.Maps[i].Loads = a.maps[i]
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// Keeps the real time of a run going across segments. Time is reset whenever a new segment starts,
+// so the time that was lost at each reset is added back onto the lines after it. The time between segments isn't counted.
+type runClock struct {
+	started bool
+	last    float32
+	offset  float64 // Seconds to add to the time of the last line.
+}
+
+// Moves the clock on to the next line's time. It must be called with every line in order.
+func (c *runClock) advance(t float32) {
+	if c.started && isTimeReset(float64(t-c.last)) {
+		c.offset += float64(c.last - t)
+	}
+	c.started, c.last = true, t
+}
+
+// The real time from a line to the last line that the clock was advanced to, given the line's time and the clock's offset at the time.
+func (c *runClock) since(start float32, startOffset float64) time.Duration {
+	return time.Duration((c.last-start)*float32(time.Second)) + time.Duration((c.offset-startOffset)*float64(time.Second))
+}
+
+// The real time of the last line that the clock was advanced to, counting every segment before it.
+func (c *runClock) now() time.Duration {
+	return c.since(0, 0)
+}
+
+// Works out how long a run and each of its maps took without loading, which is how speedruns of Half-Life 2 are timed.
+// Nothing is recorded while the game loads, so loads show up as gaps between lines. Changing maps always means loading,
+// so the time between the last line of a map and the first line of the next one is never game time either.
+type gameTimeAnalyzer struct {
+	run  float64
+	maps []float64
+
+	started  bool
+	mapName  string
+	lastTime float32
+}
+
+func newGameTimeAnalyzer() Analyzer {
+	return new(gameTimeAnalyzer)
+}
+
+func (a *gameTimeAnalyzer) Begin(header *runfile.Header) {}
+
+func (a *gameTimeAnalyzer) Line(line *runfile.Line) {
+	defer func() { a.lastTime = line.Time }()
+
+	if !a.started || (len(line.MapName) > 0 && a.mapName != line.MapName) {
+		a.started, a.mapName = true, line.MapName
+		a.maps = append(a.maps, 0)
+		return
+	}
+
+	if dt := float64(line.Time - a.lastTime); dt > 0 && dt <= maxSampleGap {
+		a.run += dt
+		a.maps[len(a.maps)-1] += dt
+	}
+}
+
+func (a *gameTimeAnalyzer) End(result *Result, complete bool) {
+	result.GameTime = time.Duration(a.run * float64(time.Second))
+	for i := range result.Maps {
+		result.Maps[i].GameTime = time.Duration(a.maps[i] * float64(time.Second))
+	}
+}
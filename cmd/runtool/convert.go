@@ -0,0 +1,288 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build !appengine
+// +build !appengine
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// An entire run held in memory.
+type run struct {
+	Version byte // Only informational. Runs are always written using the current version of the run file format.
+	Header  runfile.Header
+	Lines   []runfile.Line
+}
+
+type format struct {
+	read  func(r io.Reader) (*run, error)
+	write func(w io.Writer, run *run) error
+}
+
+var formats = map[string]format{
+	"run":  {readRunFile, writeRunFile},
+	"json": {readJSON, writeJSON},
+	"csv":  {readCSV, writeCSV},
+}
+
+// Returns the named format, or the format matching the file's extension if no name is given.
+func getFormat(name, filename string) (format, error) {
+	if len(name) == 0 {
+		name = strings.TrimPrefix(filepath.Ext(filename), ".")
+	}
+
+	f, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return format{}, fmt.Errorf("unknown format %q for %s", name, filename)
+	}
+	return f, nil
+}
+
+func readRunFile(rd io.Reader) (*run, error) {
+	r := runfile.NewReader(rd)
+	version, err := r.VerifyPreamble()
+	if err != nil {
+		return nil, err
+	}
+	header, err := r.ReadHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	run := &run{Version: version, Header: *header}
+	for {
+		var line runfile.Line
+		if err := r.ReadLineInto(&line); err == io.EOF {
+			return run, nil
+		} else if err != nil {
+			return nil, err
+		}
+		run.Lines = append(run.Lines, line)
+	}
+}
+
+func writeRunFile(wr io.Writer, run *run) error {
+	w := runfile.NewWriter(wr)
+	if err := w.WritePreamble(); err != nil {
+		return err
+	}
+	if err := w.WriteHeader(&run.Header); err != nil {
+		return err
+	}
+	for i := range run.Lines {
+		if err := w.WriteLine(&run.Lines[i]); err != nil {
+			return fmt.Errorf("line #%d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+// The JSON format mirrors run, but JSON has no numbers for NaN or infinity, which broken run files can contain.
+// They're written as the strings "NaN", "+Inf" and "-Inf" instead.
+type jsonFloat float32
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if v := float64(f); math.IsNaN(v) || math.IsInf(v, 0) {
+		return json.Marshal(formatFloat(float32(f)))
+	}
+	return []byte(formatFloat(float32(f))), nil
+}
+
+func (f *jsonFloat) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if len(b) > 0 && b[0] == '"' {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+	value, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		return err
+	}
+	*f = jsonFloat(value)
+	return nil
+}
+
+type jsonLine struct {
+	MapName    string
+	PlayerName string
+
+	Time jsonFloat
+	X    jsonFloat
+	Y    jsonFloat
+	Z    jsonFloat
+}
+
+type jsonRun struct {
+	Version byte
+	Header  runfile.Header
+	Lines   []jsonLine
+}
+
+func readJSON(r io.Reader) (*run, error) {
+	in := new(jsonRun)
+	if err := json.NewDecoder(r).Decode(in); err != nil {
+		return nil, err
+	}
+
+	run := &run{Version: in.Version, Header: in.Header}
+	if in.Lines != nil {
+		run.Lines = make([]runfile.Line, len(in.Lines))
+	}
+	for i, line := range in.Lines {
+		run.Lines[i] = runfile.Line{
+			MapName:    line.MapName,
+			PlayerName: line.PlayerName,
+
+			Time: float32(line.Time),
+			X:    float32(line.X),
+			Y:    float32(line.Y),
+			Z:    float32(line.Z),
+		}
+	}
+	return run, nil
+}
+
+func writeJSON(w io.Writer, run *run) error {
+	out := &jsonRun{Version: run.Version, Header: run.Header}
+	if run.Lines != nil {
+		out.Lines = make([]jsonLine, len(run.Lines))
+	}
+	for i, line := range run.Lines {
+		out.Lines[i] = jsonLine{
+			MapName:    line.MapName,
+			PlayerName: line.PlayerName,
+
+			Time: jsonFloat(line.Time),
+			X:    jsonFloat(line.X),
+			Y:    jsonFloat(line.Y),
+			Z:    jsonFloat(line.Z),
+		}
+	}
+
+	b, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// The CSV format has two tables: the header's column names followed by its values, then the lines' column names followed by every line.
+var (
+	csvHeaderColumns = []string{"version", "game", "ghost_r", "ghost_g", "ghost_b", "trail_r", "trail_g", "trail_b", "trail_length"}
+	csvLineColumns   = []string{"map", "player", "time", "x", "y", "z"}
+)
+
+func formatFloat(f float32) string {
+	return strconv.FormatFloat(float64(f), 'g', -1, 32)
+}
+
+func writeCSV(wr io.Writer, run *run) error {
+	w := csv.NewWriter(wr)
+
+	h := run.Header
+	w.Write(csvHeaderColumns)
+	headerValues := make([]string, 0, len(csvHeaderColumns))
+	for _, b := range []byte{run.Version, h.Game, h.GhostColorR, h.GhostColorG, h.GhostColorB, h.TrailColorR, h.TrailColorG, h.TrailColorB, h.TrailLength} {
+		headerValues = append(headerValues, strconv.Itoa(int(b)))
+	}
+	w.Write(headerValues)
+
+	w.Write(csvLineColumns)
+	for _, line := range run.Lines {
+		w.Write([]string{line.MapName, line.PlayerName, formatFloat(line.Time), formatFloat(line.X), formatFloat(line.Y), formatFloat(line.Z)})
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+func expectColumns(r *csv.Reader, columns []string) error {
+	record, err := r.Read()
+	if err != nil {
+		return err
+	}
+	if strings.Join(record, ",") != strings.Join(columns, ",") {
+		return fmt.Errorf("expected the columns %q, got %q", columns, record)
+	}
+	return nil
+}
+
+func readCSV(rd io.Reader) (*run, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1 // The two tables have different numbers of columns.
+
+	if err := expectColumns(r, csvHeaderColumns); err != nil {
+		return nil, err
+	}
+	record, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(record) != len(csvHeaderColumns) {
+		return nil, fmt.Errorf("expected %d header values, got %d", len(csvHeaderColumns), len(record))
+	}
+	values := make([]byte, len(record))
+	for i, field := range record {
+		value, err := strconv.ParseUint(field, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", csvHeaderColumns[i], err)
+		}
+		values[i] = byte(value)
+	}
+
+	run := &run{
+		Version: values[0],
+		Header: runfile.Header{
+			Game: values[1],
+
+			GhostColorR: values[2],
+			GhostColorG: values[3],
+			GhostColorB: values[4],
+
+			TrailColorR: values[5],
+			TrailColorG: values[6],
+			TrailColorB: values[7],
+			TrailLength: values[8],
+		},
+	}
+
+	if err := expectColumns(r, csvLineColumns); err != nil {
+		return nil, err
+	}
+	for i := 1; ; i++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return run, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(record) != len(csvLineColumns) {
+			return nil, fmt.Errorf("line #%d: expected %d values, got %d", i, len(csvLineColumns), len(record))
+		}
+
+		line := runfile.Line{MapName: record[0], PlayerName: record[1]}
+		for j, f := range []*float32{&line.Time, &line.X, &line.Y, &line.Z} {
+			value, err := strconv.ParseFloat(record[2+j], 32)
+			if err != nil {
+				return nil, fmt.Errorf("line #%d: %s: %s", i, csvLineColumns[2+j], err)
+			}
+			*f = float32(value)
+		}
+		run.Lines = append(run.Lines, line)
+	}
+}
@@ -0,0 +1,73 @@
+//go:build !appengine
+// +build !appengine
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+var testRun = &run{
+	Version: runfile.CurrentVersion,
+	Header:  runfile.Header{Game: 0x00, GhostColorR: 255, GhostColorG: 128, TrailColorB: 255, TrailLength: 5},
+	Lines: []runfile.Line{
+		{MapName: "d1_trainstation_01", PlayerName: "runner", Time: 0, X: -14576, Y: -13424, Z: -3160},
+		{MapName: "", PlayerName: "", Time: 0.015, X: -14575.5, Y: 0.1, Z: -3160},
+		{MapName: "d1_trainstation_02", PlayerName: "a \"quoted\", name", Time: 61.5, X: -5120, Y: -4608, Z: 12.03125},
+	},
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for name, f := range formats {
+		buf := new(bytes.Buffer)
+		if err := f.write(buf, testRun); err != nil {
+			t.Errorf("%s: unable to write: %s", name, err)
+			continue
+		}
+
+		run, err := f.read(buf)
+		if err != nil {
+			t.Errorf("%s: unable to read: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(run, testRun) {
+			t.Errorf("%s: expected %#v, got %#v", name, testRun, run)
+		}
+	}
+}
+
+func TestConvertNonFinite(t *testing.T) {
+	t.Parallel()
+
+	nonFinite := &run{
+		Version: runfile.CurrentVersion,
+		Lines:   []runfile.Line{{MapName: "d1_trainstation_01", Time: 1, X: float32(math.NaN()), Y: float32(math.Inf(1)), Z: float32(math.Inf(-1))}},
+	}
+	for name, f := range formats {
+		buf := new(bytes.Buffer)
+		if err := f.write(buf, nonFinite); err != nil {
+			t.Errorf("%s: unable to write: %s", name, err)
+			continue
+		}
+
+		run, err := f.read(buf)
+		if err != nil {
+			t.Errorf("%s: unable to read: %s", name, err)
+			continue
+		}
+		if len(run.Lines) != 1 {
+			t.Errorf("%s: expected 1 line, got %d", name, len(run.Lines))
+			continue
+		}
+		if line := run.Lines[0]; line.Time != 1 || !math.IsNaN(float64(line.X)) || !math.IsInf(float64(line.Y), 1) || !math.IsInf(float64(line.Z), -1) {
+			t.Errorf("%s: expected the numbers to survive, got %#v", name, line)
+		}
+	}
+}
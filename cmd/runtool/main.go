@@ -0,0 +1,240 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build !appengine
+// +build !appengine
+
+// Command runtool inspects, validates and converts run files without having to upload them.
+//
+// Usage:
+//
+//	runtool info <run file>
+//	runtool validate <run file>
+//	runtool dump <run file>
+//	runtool convert [-from format] [-to format] <input> <output>
+//
+// The formats understood by convert are run, json and csv. If a format isn't given, it's guessed from the file's extension.
+//
+// This is built with the !appengine tag because App Engine refuses to deploy an app containing a main package.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/HL2-Ghosting-Team/website/analysis"
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+var commands = map[string]func(args []string) error{
+	"info":     info,
+	"validate": validate,
+	"dump":     dump,
+	"convert":  convert,
+}
+
+const usageText = `Usage:
+	runtool info <run file>
+	runtool validate <run file>
+	runtool dump <run file>
+	runtool convert [-from format] [-to format] <input> <output>
+`
+
+func usage() {
+	fmt.Fprint(os.Stderr, usageText)
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", os.Args[1])
+		usage()
+	}
+
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "runtool %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// Opens a run file and reads everything up to the first line.
+func openRun(args []string) (f *os.File, r *runfile.Reader, header *runfile.Header, err error) {
+	if len(args) != 1 {
+		usage()
+	}
+
+	if f, err = os.Open(args[0]); err != nil {
+		return
+	}
+
+	r = runfile.NewReader(f)
+	if _, err = r.VerifyPreamble(); err == nil {
+		header, err = r.ReadHeader()
+	}
+	if err != nil {
+		f.Close()
+	}
+	return
+}
+
+func colorHex(r, g, b byte) string {
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+func info(args []string) error {
+	f, r, header, err := openRun(args)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	result, err := analysis.Analyze(r, header)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Version:\t%d\n", r.Version)
+	fmt.Fprintf(w, "Game:\t%d\n", header.Game)
+	fmt.Fprintf(w, "Ghost color:\t%s\n", colorHex(header.GhostColorR, header.GhostColorG, header.GhostColorB))
+	fmt.Fprintf(w, "Trail color:\t%s\n", colorHex(header.TrailColorR, header.TrailColorG, header.TrailColorB))
+	fmt.Fprintf(w, "Trail length:\t%s\n", header.TrailDuration())
+	fmt.Fprintf(w, "Lines:\t%d (%.0f a second)\n", result.Lines, result.SampleRate)
+	fmt.Fprintf(w, "Total time:\t%s (%s without loads)\n", result.TotalTime, result.GameTime)
+	fmt.Fprintf(w, "Players:\t%s\n", strings.Join(result.Players, ", "))
+	fmt.Fprintf(w, "Segments:\t%d (%d loads)\n", result.Segments, result.Loads)
+	fmt.Fprintf(w, "Distance:\t%.0f units\n", result.Distance)
+	fmt.Fprintf(w, "Speed:\t%.0f u/s average, %.0f u/s peak\n", result.AverageSpeed, result.PeakSpeed)
+	fmt.Fprintf(w, "Maps:\t\n")
+	for _, m := range result.Maps {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%.0f u/s average, %.0f u/s peak\n", m.Name, m.Time, m.GameTime, m.AverageSpeed, m.PeakSpeed)
+	}
+	if len(result.Legs) > 1 {
+		fmt.Fprintf(w, "Legs:\t\n")
+		for _, leg := range result.Legs {
+			fmt.Fprintf(w, "  %s\t%s to %s\t%s\n", leg.Player, leg.Start, leg.End, leg.Duration())
+		}
+	}
+	if len(result.Idle) > 0 {
+		fmt.Fprintf(w, "Idle:\t\n")
+		for _, idle := range result.Idle {
+			fmt.Fprintf(w, "  lines %d-%d\t%s to %s\t%s\n", idle.StartLine, idle.EndLine, idle.Start, idle.End, idle.Duration())
+		}
+	}
+	if len(result.Flags) > 0 {
+		fmt.Fprintf(w, "Flags:\t\n")
+		for _, flag := range result.Flags {
+			fmt.Fprintf(w, "  line %d at %s\t%s at %.0f, %.0f, %.0f\n", flag.Line, flag.Time, flag, flag.X, flag.Y, flag.Z)
+		}
+	}
+	return w.Flush()
+}
+
+// Performs the same checks that the website does when a run is uploaded and analyzed.
+func validate(args []string) error {
+	f, r, header, err := openRun(args)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The website supports the games that it has limits for.
+	if _, ok := analysis.LimitsFor(header.Game); !ok {
+		return fmt.Errorf("the website doesn't support game %d", header.Game)
+	}
+
+	result, err := analysis.Analyze(r, header)
+	if analysis.Salvageable(result, err) {
+		return fmt.Errorf("only the first %d maps could be analyzed, so the run couldn't be ranked: %s", len(result.Maps), err)
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: valid, %d lines over %s\n", args[0], result.Lines, result.TotalTime)
+	if len(result.Flags) > 0 {
+		fmt.Printf("%s: flagged %d times, so a verifier would have to review the flags before ranking it\n", args[0], len(result.Flags))
+	}
+	return nil
+}
+
+func dump(args []string) error {
+	f, r, header, err := openRun(args)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(w, "# version %d, header %+v\n", r.Version, *header)
+	fmt.Fprintln(w, "# line\ttime\tmap\tplayer\tx\ty\tz")
+
+	line := new(runfile.Line)
+	for i := 1; ; i++ {
+		if err := r.ReadLineInto(line); err == io.EOF {
+			break
+		} else if err != nil {
+			w.Flush()
+			return err
+		}
+
+		fmt.Fprintf(w, "%d\t%g\t%q\t%q\t%g\t%g\t%g\n", i, line.Time, line.MapName, line.PlayerName, line.X, line.Y, line.Z)
+	}
+	return w.Flush()
+}
+
+func convert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := flags.String("from", "", "the format of the input (run, json or csv)")
+	to := flags.String("to", "", "the format of the output (run, json or csv)")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		usage()
+	}
+	input, output := flags.Arg(0), flags.Arg(1)
+
+	inputFormat, err := getFormat(*from, input)
+	if err != nil {
+		return err
+	}
+	outputFormat, err := getFormat(*to, output)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	run, err := inputFormat.read(in)
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", input, err)
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	if err := outputFormat.write(w, run); err != nil {
+		out.Close()
+		return fmt.Errorf("writing %s: %s", output, err)
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
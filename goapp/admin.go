@@ -0,0 +1,139 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine/datastore"
+	"net/http"
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/models"
+)
+
+const (
+	stuckAnalysisAge = time.Hour // How long a run can wait to be analyzed before it's considered stuck.
+	adminRunsLimit   = 50
+)
+
+type adminRunInternal struct {
+	Run    *models.Run
+	RunKey *datastore.Key
+}
+
+// Writes an error and returns false if the current user isn't an administrator.
+// app.yaml already requires administrators for these pages, but this keeps the handlers safe if that ever changes.
+func requireAdmin(c *Context) bool {
+	if currentUser := c.CurrentUser(); currentUser == nil || !currentUser.Admin {
+		c.Infof("Attempted to use an admin page and they aren't an admin.")
+		http.Error(c.Response, "You must be an administrator to view this page.", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Fetches the runs matched by q for the admin pages.
+func fetchAdminRuns(c *Context, q *datastore.Query) []*adminRunInternal {
+	runs := make([]*adminRunInternal, 0, adminRunsLimit)
+	for it := c.Goon.Run(q.Limit(adminRunsLimit)); ; {
+		run := new(models.Run)
+		if _, err := it.Next(run); err == datastore.Done {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+
+		runs = append(runs, &adminRunInternal{
+			Run:    run,
+			RunKey: c.Goon.Key(run),
+		})
+	}
+	return runs
+}
+
+// Lists the runs that have been waiting to be analyzed for too long, the runs that have been dead-lettered,
+// and the unranked runs that were flagged by their analysis.
+func AdminRuns(c *Context) {
+	if !requireAdmin(c) {
+		return
+	}
+
+	stuckChan := make(chan []*adminRunInternal, 1)
+	go c.Step("fetch stuck runs", func(c *Context) {
+		q := datastore.NewQuery("Run").Filter("AnalysisPending =", true).Filter("AnalysisQueueTime <", time.Now().Add(-stuckAnalysisAge)).Order("AnalysisQueueTime")
+		stuckChan <- fetchAdminRuns(c, q)
+	})
+
+	flaggedChan := make(chan []*adminRunInternal, 1)
+	go c.Step("fetch flagged runs", func(c *Context) {
+		q := datastore.NewQuery("Run").Filter("Flagged =", true).Filter("Ranked =", false).Order("-UploadTime")
+		flaggedChan <- fetchAdminRuns(c, q)
+	})
+
+	c.Step("fetch dead-lettered runs", func(c *Context) {
+		q := datastore.NewQuery("Run").Filter("DeadLettered =", true).Order("-AnalysisQueueTime")
+		c.SetRenderParam("DeadLetteredRuns", fetchAdminRuns(c, q))
+	})
+
+	c.SetRenderParam("StuckRuns", <-stuckChan)
+	c.SetRenderParam("FlaggedRuns", <-flaggedChan)
+	c.SetRenderParam("StuckAnalysisAge", stuckAnalysisAge)
+	c.SetRenderParam("MaxAnalysisAttempts", maxAnalysisAttempts)
+
+	c.Render()
+}
+
+// Queues a stuck or dead-lettered run to be analyzed again.
+func AdminRunsPOST(c *Context) {
+	if !requireAdmin(c) {
+		return
+	}
+
+	runIDstr := c.Req.PostFormValue("id")
+	runKey, err := datastore.DecodeKey(runIDstr)
+	if err != nil {
+		c.Infof("Unable to decode run key (%s): %s", runIDstr, err)
+		http.Error(c.Response, "Invalid run ID: "+runIDstr, http.StatusBadRequest)
+		return
+	}
+
+	switch action := c.Req.PostFormValue("action"); action {
+	case "requeue":
+		requeued := false
+		c.Step("requeue run", func(c *Context) {
+			if err := c.RunInTransaction(func(c *Context) error {
+				run := &models.Run{ID: runKey.IntID(), User: runKey.Parent()}
+				if err := c.Goon.Get(run); err != nil {
+					return err
+				}
+				if run.Deleted || len(run.RunFile) == 0 {
+					return nil
+				}
+
+				requeued = true
+				return queueAnalysis(c, run, false)
+			}, nil); err == datastore.ErrNoSuchEntity {
+				return
+			} else if err != nil {
+				panic(err)
+			}
+		})
+		if !requeued {
+			http.Error(c.Response, "This run can't be queued to be analyzed.", http.StatusBadRequest)
+			return
+		}
+		c.Infof("Administrator %s queued run %s to be analyzed again", c.CurrentUser().ID, runKey.Encode())
+	default:
+		c.Infof("Unknown action: %s", action)
+		http.Error(c.Response, "Unknown action: "+action, http.StatusBadRequest)
+		return
+	}
+
+	adminURL, err := routerUrl("admin-runs")
+	if err != nil {
+		panic(err)
+	}
+
+	http.Redirect(c.Response, c.Req, adminURL, http.StatusSeeOther)
+}
@@ -0,0 +1,171 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine/datastore"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/HL2-Ghosting-Team/website/models"
+)
+
+var categoryIDPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// Asks for the leaderboard of runs without a category, which games keep once they have categories so that older runs are still ranked.
+// No category can have it as its ID.
+const uncategorized = "uncategorized"
+
+// Gets the categories of a game, in order of their names.
+func fetchCategories(c *Context, game byte) []*models.Category {
+	categories := make([]*models.Category, 0)
+	if _, err := c.Goon.GetAll(datastore.NewQuery("Category").Filter("Game =", int(game)).Order("Name"), &categories); err != nil {
+		panic(err)
+	}
+	return categories
+}
+
+// Gets the categories of every game, in order of their games and then their names.
+func fetchAllCategories(c *Context) []*models.Category {
+	categories := make([]*models.Category, 0)
+	if _, err := c.Goon.GetAll(datastore.NewQuery("Category").Order("Game").Order("Name"), &categories); err != nil {
+		panic(err)
+	}
+	return categories
+}
+
+// Gets the category with the given ID, or nil if there isn't one.
+func fetchCategory(c *Context, id string) (*models.Category, error) {
+	category := &models.Category{ID: id}
+	if err := c.Goon.Get(category); err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+// Picks the category that was asked for out of a game's categories, falling back to the first one.
+// It returns nil if the game doesn't have any categories, or if runs without a category were asked for.
+func getCategory(c *Context, categories []*models.Category) *models.Category {
+	requested := c.Req.FormValue("category")
+	if requested == uncategorized {
+		return nil
+	}
+	for _, category := range categories {
+		if category.ID == requested {
+			return category
+		}
+	}
+	if len(categories) > 0 {
+		return categories[0]
+	}
+	return nil
+}
+
+// Lists the categories of every game, along with a form for adding one or changing the one being edited.
+func AdminCategories(c *Context) {
+	if !requireAdmin(c) {
+		return
+	}
+
+	var categories []*models.Category
+	c.Step("fetch categories", func(c *Context) {
+		categories = fetchAllCategories(c)
+	})
+
+	editing := &models.Category{Game: models.DefaultGame, Ruleset: models.Ruleset{MaxLoads: -1}}
+	if id := c.Req.FormValue("id"); len(id) > 0 {
+		for _, category := range categories {
+			if category.ID == id {
+				editing = category
+			}
+		}
+	}
+
+	c.FillRenderParams(map[string]interface{}{
+		"Categories": categories,
+		"Editing":    editing,
+		"GameNames":  models.PrettyGameNames,
+	})
+
+	c.Render()
+}
+
+// Adds a category or changes an existing one. Changes to a ruleset only apply to runs analyzed after them.
+func AdminCategoriesPOST(c *Context) {
+	if !requireAdmin(c) {
+		return
+	}
+
+	form := c.Req.PostFormValue
+	category := &models.Category{
+		ID:          strings.TrimSpace(form("id")),
+		Name:        strings.TrimSpace(form("name")),
+		Description: strings.TrimSpace(form("description")),
+		Ruleset: models.Ruleset{
+			Timing:         form("timing"),
+			AllowSegmented: form("allow_segmented") != "",
+			StartMap:       strings.TrimSpace(form("start_map")),
+			EndMap:         strings.TrimSpace(form("end_map")),
+		},
+	}
+	if !categoryIDPattern.MatchString(category.ID) {
+		http.Error(c.Response, "The ID must be up to 32 lowercase letters, numbers, dashes and underscores.", http.StatusBadRequest)
+		return
+	}
+	if category.ID == uncategorized {
+		http.Error(c.Response, "The ID "+uncategorized+" is reserved for runs without a category.", http.StatusBadRequest)
+		return
+	}
+	if len(category.Name) == 0 {
+		http.Error(c.Response, "A name is required.", http.StatusBadRequest)
+		return
+	}
+	if _, ok := timings[category.Timing]; !ok && len(category.Timing) > 0 {
+		http.Error(c.Response, "Unknown timing: "+category.Timing, http.StatusBadRequest)
+		return
+	}
+
+	game, err := strconv.Atoi(form("game"))
+	if err != nil || game < 0 || game > 0xff {
+		http.Error(c.Response, "Invalid game: "+form("game"), http.StatusBadRequest)
+		return
+	}
+	if _, ok := models.PrettyGameNames[byte(game)]; !ok {
+		http.Error(c.Response, "Unknown game: "+form("game"), http.StatusBadRequest)
+		return
+	}
+	category.Game = game
+
+	if category.MaxLoads, err = strconv.Atoi(form("max_loads")); err != nil {
+		http.Error(c.Response, "Invalid number of loads: "+form("max_loads"), http.StatusBadRequest)
+		return
+	}
+	if category.MinSampleRate, err = strconv.ParseFloat(form("min_sample_rate"), 64); err != nil || category.MinSampleRate < 0 {
+		http.Error(c.Response, "Invalid minimum sample rate: "+form("min_sample_rate"), http.StatusBadRequest)
+		return
+	}
+	if category.MaxSampleRate, err = strconv.ParseFloat(form("max_sample_rate"), 64); err != nil || category.MaxSampleRate < 0 {
+		http.Error(c.Response, "Invalid maximum sample rate: "+form("max_sample_rate"), http.StatusBadRequest)
+		return
+	}
+
+	c.Step("store category", func(c *Context) {
+		if _, err := c.Goon.Put(category); err != nil {
+			panic(err)
+		}
+	})
+	c.Infof("Administrator %s stored category %s: %+v", c.CurrentUser().ID, category.ID, category)
+
+	adminURL, err := routerUrl("admin-categories")
+	if err != nil {
+		panic(err)
+	}
+
+	http.Redirect(c.Response, c.Req, adminURL, http.StatusSeeOther)
+}
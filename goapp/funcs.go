@@ -8,7 +8,9 @@ import (
 	"appengine/datastore"
 	"fmt"
 	"html/template"
+	"net/url"
 	"reflect"
+	"strings"
 
 	"github.com/ftrvxmtrx/gravatar"
 
@@ -45,11 +47,16 @@ func set(renderArgs Includes, key string, value interface{}) template.HTML {
 	return template.HTML("")
 }
 
+// Escapes a string so that it can be used as one segment of a URL's path, such as a map name from a run file.
+func escapePathSegment(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
 func routerUrl(name string, pairsRaw ...interface{}) (string, error) {
 	if route, ok := routes[name]; ok {
 		pairs := make([]string, len(pairsRaw))
 		for i, value := range pairsRaw {
-			pairs[i] = fmt.Sprintf("%v", value)
+			pairs[i] = escapePathSegment(fmt.Sprintf("%v", value))
 		}
 
 		return route.URLWith(pairs), nil
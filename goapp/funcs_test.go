@@ -25,3 +25,19 @@ func TestEq(t *testing.T) {
 	eqHelper(t, false, 1, 2)
 	eqHelper(t, false, 1, 2, 3)
 }
+
+func TestEscapePathSegment(t *testing.T) {
+	t.Parallel()
+
+	for s, expected := range map[string]string{
+		"d1_trainstation_01":     "d1_trainstation_01",
+		"my map":                 "my%20map",
+		"a/b?c#d":                "a%2Fb%3Fc%23d",
+		"\"><script>":            "%22%3E%3Cscript%3E",
+		"agxzfmhsMmdob3N0aW5ncg": "agxzfmhsMmdob3N0aW5ncg",
+	} {
+		if escaped := escapePathSegment(s); escaped != expected {
+			t.Errorf("Expected %q to be escaped to %q, got %q", s, expected, escaped)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine/datastore"
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/models"
+)
+
+// Gets the time that is ranked by in one of the timings.
+func timingValue(timing string, totalTime, gameTime time.Duration) time.Duration {
+	if timings[timing] == "GameTime" {
+		return gameTime
+	}
+	return totalTime
+}
+
+// Picks the best of a user's n times in each timing, returning their indexes. Ties go to the time that was uploaded first.
+// Times that aren't eligible, or that are 0, are never picked.
+func pickBest(n int, eligible func(i int) bool, value func(i int, timing string) time.Duration, uploaded func(i int) time.Time) map[string]int {
+	best := make(map[string]int, len(timings))
+	for i := 0; i < n; i++ {
+		if !eligible(i) {
+			continue
+		}
+		for timing := range timings {
+			v := value(i, timing)
+			if v <= 0 {
+				continue
+			}
+			if current, ok := best[timing]; !ok || v < value(current, timing) || (v == value(current, timing) && uploaded(i).Before(uploaded(current))) {
+				best[timing] = i
+			}
+		}
+	}
+	return best
+}
+
+// Lists the timings that the time at index i is the best in.
+func bestTimingsOf(best map[string]int, i int) []string {
+	var bestTimings []string
+	for timing, index := range best {
+		if index == i {
+			bestTimings = append(bestTimings, timing)
+		}
+	}
+	return bestTimings
+}
+
+// Works out which of a user's ranked runs for a game and category is their best in each timing, and marks the rest obsolete.
+// Only the best runs are shown on the leaderboards. Ties go to the run that was uploaded first.
+// changed is a run of the user that has been changed but not stored yet. It's updated along with the others, but it's left for the caller to store.
+// This should be called in a transaction, since the runs of a user are all in the user's entity group.
+func updateBestRuns(c *Context, changed *models.Run) error {
+	changedKey := c.Goon.Key(changed)
+	runs := make([]models.Run, 0)
+	if _, err := c.Goon.GetAll(datastore.NewQuery("Run").Ancestor(changed.User), &runs); err != nil {
+		return err
+	}
+
+	candidates := []*models.Run{changed}
+	for i := range runs {
+		if run := &runs[i]; run.Game == changed.Game && run.Category == changed.Category && !c.Goon.Key(run).Equal(changedKey) {
+			candidates = append(candidates, run)
+		}
+	}
+
+	updated := markBestRuns(candidates)
+	if len(updated) == 0 {
+		return nil
+	}
+	_, err := c.Goon.PutMulti(updated)
+	return err
+}
+
+// Sets which timings each of a user's runs is the best in, and whether it's obsolete. The first run is the one that changed.
+// It returns the other runs that have to be stored because they changed too.
+func markBestRuns(candidates []*models.Run) []*models.Run {
+	// Individual level runs are only on the map leaderboards.
+	eligible := func(i int) bool {
+		return candidates[i].Ranked && !candidates[i].Deleted && !candidates[i].IndividualLevel
+	}
+	best := pickBest(len(candidates), eligible, func(i int, timing string) time.Duration {
+		return timingValue(timing, candidates[i].TotalTime, candidates[i].GameTime)
+	}, func(i int) time.Time {
+		return candidates[i].UploadTime
+	})
+
+	updated := make([]*models.Run, 0, len(candidates))
+	for i, run := range candidates {
+		bestTimings := bestTimingsOf(best, i)
+		obsolete := eligible(i) && len(bestTimings) == 0
+
+		if i > 0 && run.Obsolete == obsolete && sameTimings(run.BestTimings, bestTimings) {
+			continue
+		}
+		run.BestTimings, run.Obsolete = bestTimings, obsolete
+		if i > 0 {
+			updated = append(updated, run)
+		}
+	}
+	return updated
+}
+
+func sameTimings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, timing := range a {
+		found := false
+		for _, other := range b {
+			if timing == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Works out the ranks of a page of a leaderboard, which starts offset times in. Equal times share a rank,
+// which is one more than the number of times that are faster than them. countFaster counts the times on the whole leaderboard that are faster than a time.
+func rankTimes(values []time.Duration, offset int, countFaster func(value time.Duration) (int, error)) ([]int, error) {
+	ranks := make([]int, len(values))
+	for i, value := range values {
+		if i > 0 && value == values[i-1] {
+			ranks[i] = ranks[i-1]
+		} else if i > 0 || offset == 0 {
+			ranks[i] = offset + i + 1
+		} else {
+			// The times on the previous page may be the same as the first time on this one.
+			faster, err := countFaster(value)
+			if err != nil {
+				return nil, err
+			}
+			ranks[i] = faster + 1
+		}
+	}
+	return ranks, nil
+}
@@ -0,0 +1,172 @@
+package goapp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/models"
+)
+
+func TestRankTimes(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		values   []time.Duration
+		offset   int
+		faster   int // What the leaderboard counts as faster than the first time on a later page.
+		expected []int
+	}{
+		{"no ties", []time.Duration{1, 2, 3}, 0, 0, []int{1, 2, 3}},
+		{"tie skips the next rank", []time.Duration{1, 2, 2, 3}, 0, 0, []int{1, 2, 2, 4}},
+		{"all tied", []time.Duration{5, 5, 5}, 0, 0, []int{1, 1, 1}},
+		{"later page", []time.Duration{6, 7}, 10, 10, []int{11, 12}},
+		{"tie with the previous page", []time.Duration{5, 5, 6}, 10, 8, []int{9, 9, 13}},
+	} {
+		ranks, err := rankTimes(test.values, test.offset, func(value time.Duration) (int, error) {
+			if value != test.values[0] {
+				t.Errorf("%s: expected to count the times faster than %d, got %d", test.name, test.values[0], value)
+			}
+			return test.faster, nil
+		})
+		if err != nil {
+			t.Errorf("%s: unable to rank: %s", test.name, err)
+		} else if !reflect.DeepEqual(ranks, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, ranks)
+		}
+	}
+}
+
+func TestPickBest(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	times := []struct {
+		total, game time.Duration
+		uploaded    time.Duration
+		eligible    bool
+	}{
+		{10, 8, 0, true},
+		{9, 8, 1, true},  // Faster in real time. Ties the first in game time, but was uploaded later.
+		{5, 4, 2, false}, // Fastest, but not eligible.
+		{0, 7, 3, true},  // Only timed in game time.
+	}
+	best := pickBest(len(times), func(i int) bool {
+		return times[i].eligible
+	}, func(i int, timing string) time.Duration {
+		return timingValue(timing, times[i].total, times[i].game)
+	}, func(i int) time.Time {
+		return start.Add(times[i].uploaded)
+	})
+
+	if expected := map[string]int{"real": 1, "game": 3}; !reflect.DeepEqual(best, expected) {
+		t.Errorf("Expected %v, got %v", expected, best)
+	}
+
+	times[3].eligible = false
+	best = pickBest(len(times), func(i int) bool {
+		return times[i].eligible
+	}, func(i int, timing string) time.Duration {
+		return timingValue(timing, times[i].total, times[i].game)
+	}, func(i int) time.Time {
+		return start.Add(times[i].uploaded)
+	})
+	if expected := map[string]int{"real": 1, "game": 0}; !reflect.DeepEqual(best, expected) {
+		t.Errorf("Expected the earlier upload to win the tie, got %v", best)
+	}
+}
+
+func TestMarkBestRuns(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	bothTimings := []string{"real", "game"}
+	ranked := func(total time.Duration, uploaded time.Duration, bestTimings []string, obsolete bool) *models.Run {
+		return &models.Run{
+			Ranked:      true,
+			TotalTime:   total,
+			GameTime:    total,
+			UploadTime:  start.Add(uploaded),
+			BestTimings: bestTimings,
+			Obsolete:    obsolete,
+		}
+	}
+
+	for _, test := range []struct {
+		name       string
+		candidates []*models.Run // The first is the run that changed.
+		best       []int         // The candidates that should be best in both timings.
+		obsolete   []int
+		updated    []int
+	}{
+		{
+			name:       "slower later upload",
+			candidates: []*models.Run{ranked(20, 2, nil, false), ranked(10, 1, bothTimings, false)},
+			best:       []int{1},
+			obsolete:   []int{0},
+		},
+		{
+			name:       "faster later upload",
+			candidates: []*models.Run{ranked(5, 2, nil, false), ranked(10, 1, bothTimings, false)},
+			best:       []int{0},
+			obsolete:   []int{1},
+			updated:    []int{1},
+		},
+		{
+			name:       "tie goes to the earlier upload",
+			candidates: []*models.Run{ranked(10, 2, nil, false), ranked(10, 1, bothTimings, false)},
+			best:       []int{1},
+			obsolete:   []int{0},
+		},
+		{
+			name: "best run deleted",
+			candidates: []*models.Run{
+				{Deleted: true, TotalTime: 5, GameTime: 5, UploadTime: start, BestTimings: bothTimings},
+				ranked(10, 1, nil, true),
+			},
+			best:    []int{1},
+			updated: []int{1},
+		},
+		{
+			name: "best run rejected",
+			candidates: []*models.Run{
+				{VerificationState: models.VerificationRejected, TotalTime: 5, GameTime: 5, UploadTime: start, BestTimings: bothTimings},
+				ranked(10, 1, nil, true),
+				ranked(20, 2, nil, true),
+			},
+			best:     []int{1},
+			obsolete: []int{2},
+			updated:  []int{1},
+		},
+	} {
+		updated := markBestRuns(test.candidates)
+
+		contains := func(list []int, i int) bool {
+			for _, j := range list {
+				if i == j {
+					return true
+				}
+			}
+			return false
+		}
+		for i, run := range test.candidates {
+			if isBest := contains(test.best, i); isBest != sameTimings(run.BestTimings, bothTimings) || (!isBest && len(run.BestTimings) > 0) {
+				t.Errorf("%s: run %d: expected to be best (%t), got best timings %v", test.name, i, isBest, run.BestTimings)
+			}
+			if obsolete := contains(test.obsolete, i); run.Obsolete != obsolete {
+				t.Errorf("%s: run %d: expected to be obsolete (%t), got %t", test.name, i, obsolete, run.Obsolete)
+			}
+		}
+
+		if len(updated) != len(test.updated) {
+			t.Errorf("%s: expected %d runs to be updated, got %d", test.name, len(test.updated), len(updated))
+			continue
+		}
+		for i, run := range updated {
+			if run != test.candidates[test.updated[i]] {
+				t.Errorf("%s: expected run %d to be updated, got %+v", test.name, test.updated[i], run)
+			}
+		}
+	}
+}
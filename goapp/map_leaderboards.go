@@ -0,0 +1,252 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine/datastore"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/codegangsta/martini"
+
+	"github.com/HL2-Ghosting-Team/website/analysis"
+	"github.com/HL2-Ghosting-Team/website/models"
+)
+
+type exposedMapTime struct {
+	Rank    int
+	MapTime *models.MapTime
+	RunKey  string
+	User    *models.User
+	UserKey *datastore.Key
+}
+
+// Gets the times on the leaderboard of a map in a category and one of the timings. Each user only has their best time on it.
+// An empty category gets the times of runs without a category.
+func mapLeaderboardQuery(game int, category, mapName, timing string) *datastore.Query {
+	property := timings[timing]
+	return datastore.NewQuery("MapTime").Filter("Game =", game).Filter("Category =", category).Filter("Map =", mapName).Filter("BestTimings =", timing).Filter(property+" >", 0)
+}
+
+// A map leaderboard of a game. A run's map times are only compared with times in the same category.
+type mapLeaderboard struct {
+	Map      string
+	Category string
+}
+
+// Picks a user's times that are on a leaderboard, out of their stored times on its map and the changed run's current times.
+// The changed run's stored times are left out, since they're being replaced.
+func mapTimeCandidates(board mapLeaderboard, game int, stored []models.MapTime, current []*models.MapTime, fromRun func(*models.MapTime) bool) []*models.MapTime {
+	candidates := make([]*models.MapTime, 0, len(stored)+1)
+	for i := range stored {
+		if stored[i].Game == game && stored[i].Category == board.Category && stored[i].Map == board.Map && !fromRun(&stored[i]) {
+			candidates = append(candidates, &stored[i])
+		}
+	}
+	for _, mapTime := range current {
+		if mapTime.Category == board.Category && mapTime.Map == board.Map {
+			candidates = append(candidates, mapTime)
+		}
+	}
+	return candidates
+}
+
+// Works out how long a run spent on each of its maps, in the order that they were first played.
+// A map that was played more than once, such as by going back to it, is given the time of every visit added together so that it's never ranked by a part of it.
+func mergeMapVisits(maps []analysis.Map) []analysis.Map {
+	merged := make([]analysis.Map, 0, len(maps))
+	indexes := make(map[string]int, len(maps))
+	for _, m := range maps {
+		if i, ok := indexes[m.Name]; ok {
+			merged[i].Time += m.Time
+			merged[i].GameTime += m.GameTime
+			continue
+		}
+		indexes[m.Name] = len(merged)
+		merged = append(merged, analysis.Map{Name: m.Name, Time: m.Time, GameTime: m.GameTime})
+	}
+	return merged
+}
+
+// Stores the map times of a run if it's ranked, or removes them if it isn't, then works out the user's best time on each of the run's maps.
+// run has been changed but may not have been stored yet. This should be called in the transaction that stores it.
+// result is the run's analysis, or nil to fetch it. It has to be given if the analysis is stored in the same transaction, since the transaction can't see it yet.
+func updateMapTimes(c *Context, run *models.Run, result *analysis.Result) error {
+	runKey := c.Goon.Key(run)
+
+	old := make([]models.MapTime, 0)
+	if _, err := c.Goon.GetAll(datastore.NewQuery("MapTime").Ancestor(runKey), &old); err != nil {
+		return err
+	}
+
+	current := make([]*models.MapTime, 0)
+	if run.Ranked && !run.Deleted && run.FullAnalysis != nil {
+		if result == nil {
+			fullAnalysis := &models.Analysis{ID: run.FullAnalysis.IntID(), Run: runKey}
+			if err := c.Goon.Get(fullAnalysis); err != nil {
+				return err
+			}
+			result = &fullAnalysis.Result
+		}
+		for i, m := range mergeMapVisits(result.Maps) {
+			current = append(current, &models.MapTime{
+				ID:  int64(i + 1),
+				Run: runKey,
+
+				Game:       run.Game,
+				Category:   run.Category,
+				Map:        m.Name,
+				TotalTime:  m.Time,
+				GameTime:   m.GameTime,
+				UploadTime: run.UploadTime,
+			})
+		}
+	}
+
+	// The leaderboards whose bests might have changed. The run's old times may be in another category, if it was moved.
+	boards := make(map[mapLeaderboard]bool)
+	removed := make([]*datastore.Key, 0)
+	for i := range old {
+		boards[mapLeaderboard{Map: old[i].Map, Category: old[i].Category}] = true
+		if int(old[i].ID) > len(current) {
+			removed = append(removed, c.Goon.Key(&old[i]))
+		}
+	}
+	for _, mapTime := range current {
+		boards[mapLeaderboard{Map: mapTime.Map, Category: mapTime.Category}] = true
+	}
+	if len(removed) > 0 {
+		if err := c.Goon.DeleteMulti(removed); err != nil {
+			return err
+		}
+	}
+
+	updated := make([]*models.MapTime, 0, len(current))
+	for board := range boards {
+		// The user's other times on the map are in the datastore, but this run's are only in current until the transaction is committed.
+		stored := make([]models.MapTime, 0)
+		if _, err := c.Goon.GetAll(datastore.NewQuery("MapTime").Ancestor(run.User).Filter("Map =", board.Map), &stored); err != nil {
+			return err
+		}
+		candidates := mapTimeCandidates(board, run.Game, stored, current, func(mapTime *models.MapTime) bool {
+			return mapTime.Run.Equal(runKey)
+		})
+
+		best := pickBest(len(candidates), func(i int) bool { return true }, func(i int, timing string) time.Duration {
+			return timingValue(timing, candidates[i].TotalTime, candidates[i].GameTime)
+		}, func(i int) time.Time {
+			return candidates[i].UploadTime
+		})
+
+		for i, mapTime := range candidates {
+			bestTimings := bestTimingsOf(best, i)
+			if mapTime.Run.Equal(runKey) || !sameTimings(mapTime.BestTimings, bestTimings) { // This run's times are always stored, since they may be new.
+				mapTime.BestTimings = bestTimings
+				updated = append(updated, mapTime)
+			}
+		}
+	}
+
+	if len(updated) == 0 {
+		return nil
+	}
+	_, err := c.Goon.PutMulti(updated)
+	return err
+}
+
+// Shows the best time of each user on a map.
+func MapLeaderboard(c *Context, params martini.Params) {
+	gameID, err := strconv.Atoi(params["game"])
+	if err != nil || gameID < 0 || gameID > 0xff {
+		NotFound(c)
+		return
+	}
+	if _, ok := models.PrettyGameNames[byte(gameID)]; !ok {
+		NotFound(c)
+		return
+	}
+	var (
+		mapName    = params["map"]
+		timing     = getTiming(c)
+		categories []*models.Category
+	)
+
+	c.Step("fetch categories", func(c *Context) {
+		categories = fetchCategories(c, byte(gameID))
+	})
+	category := getCategory(c, categories)
+	categoryID := ""
+	if category != nil {
+		categoryID = category.ID
+		if len(category.Timing) > 0 {
+			timing = category.Timing
+		}
+	}
+	page := getPageToken(c, fmt.Sprintf("maps/%d/%s/%s/%s", gameID, categoryID, mapName, timing))
+
+	mapTimes := make([]models.MapTime, runsPerPage)
+	var next *pageToken
+	c.Step("fetch times", func(c *Context) {
+		q := mapLeaderboardQuery(gameID, categoryID, mapName, timing).Order(timings[timing]).Order("__key__")
+		n, nextPage, err := runPage(c, q, page, runsPerPage, func(i int) interface{} { return &mapTimes[i] })
+		if err != nil {
+			panic(err)
+		}
+		mapTimes, next = mapTimes[:n], nextPage
+	})
+
+	exposed := make([]*exposedMapTime, len(mapTimes))
+	c.Step("fetch runners", func(c *Context) {
+		ids := make([]string, len(mapTimes))
+		for i := range mapTimes {
+			ids[i] = mapTimes[i].Run.Parent().StringID()
+		}
+		users := fetchUsers(c, ids)
+
+		for i := range mapTimes {
+			exposed[i] = &exposedMapTime{
+				MapTime: &mapTimes[i],
+				RunKey:  mapTimes[i].Run.Encode(),
+				User:    users[i],
+			}
+			if len(users[i].ID) > 0 {
+				exposed[i].UserKey = mapTimes[i].Run.Parent()
+			}
+		}
+	})
+
+	c.Step("rank times", func(c *Context) {
+		values := make([]time.Duration, len(mapTimes))
+		for i := range mapTimes {
+			values[i] = timingValue(timing, mapTimes[i].TotalTime, mapTimes[i].GameTime)
+		}
+
+		ranks, err := rankTimes(values, page.Offset, func(value time.Duration) (int, error) {
+			return c.Goon.Count(mapLeaderboardQuery(gameID, categoryID, mapName, timing).Filter(timings[timing]+" <", value))
+		})
+		if err != nil {
+			panic(err)
+		}
+		for i := range exposed {
+			exposed[i].Rank = ranks[i]
+		}
+	})
+
+	c.FillRenderParams(map[string]interface{}{
+		"Game":          gameID,
+		"Map":           mapName,
+		"Timing":        timing,
+		"Categories":    categories,
+		"Category":      category,
+		"Uncategorized": uncategorized,
+		"Times":         exposed,
+		"FirstPage":     page.Offset == 0,
+		"PrevPage":      page.previous(runsPerPage),
+		"NextPage":      next,
+	})
+
+	c.Render()
+}
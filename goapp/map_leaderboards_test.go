@@ -0,0 +1,58 @@
+package goapp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/HL2-Ghosting-Team/website/analysis"
+	"github.com/HL2-Ghosting-Team/website/models"
+)
+
+func TestMergeMapVisits(t *testing.T) {
+	t.Parallel()
+
+	maps := []analysis.Map{
+		{Name: "d1_canals_01", Time: 30, GameTime: 25},
+		{Name: "d1_canals_01a", Time: 20, GameTime: 15},
+		{Name: "d1_canals_01", Time: 5, GameTime: 4}, // Going back for something that was left behind.
+		{Name: "d1_canals_01a", Time: 2, GameTime: 1},
+		{Name: "d1_canals_02", Time: 40, GameTime: 35},
+	}
+	expected := []analysis.Map{
+		{Name: "d1_canals_01", Time: 35, GameTime: 29},
+		{Name: "d1_canals_01a", Time: 22, GameTime: 16},
+		{Name: "d1_canals_02", Time: 40, GameTime: 35},
+	}
+	if merged := mergeMapVisits(maps); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, merged)
+	}
+}
+
+func TestMapTimeCandidates(t *testing.T) {
+	t.Parallel()
+
+	board := mapLeaderboard{Map: "d1_canals_01", Category: "any"}
+	stored := []models.MapTime{
+		{Game: 0, Category: "any", Map: "d1_canals_01", TotalTime: 1},
+		{Game: 0, Category: "glitchless", Map: "d1_canals_01", TotalTime: 2}, // Another category's leaderboard.
+		{Game: 0, Category: "", Map: "d1_canals_01", TotalTime: 3},           // Runs without a category have their own leaderboard.
+		{Game: 1, Category: "any", Map: "d1_canals_01", TotalTime: 4},        // Another game's.
+		{Game: 0, Category: "any", Map: "d1_canals_02", TotalTime: 5},        // Another map's.
+		{Game: 0, Category: "any", Map: "d1_canals_01", TotalTime: 6},        // The changed run's old time.
+	}
+	current := []*models.MapTime{
+		{Game: 0, Category: "any", Map: "d1_canals_01", TotalTime: 7},
+		{Game: 0, Category: "any", Map: "d1_canals_01a", TotalTime: 8},
+	}
+
+	candidates := mapTimeCandidates(board, 0, stored, current, func(mapTime *models.MapTime) bool {
+		return mapTime == &stored[5]
+	})
+	expected := []*models.MapTime{&stored[0], current[0]}
+	if !reflect.DeepEqual(candidates, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, candidates)
+	}
+	if candidates[0] != &stored[0] || candidates[1] != current[0] {
+		t.Errorf("Expected the candidates to point at the times, so that they can be updated")
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine/datastore"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// How many results a page can start after without a cursor. Going back a page skips the results before it, which the datastore still has to read.
+const maxPageSkip = 1000
+
+// Where a page of query results starts. It's passed around as an opaque token so that nobody relies on what's in it.
+type pageToken struct {
+	Scope  string `json:"s"` // What's being paged through. Cursors only work with the query that they came from.
+	Cursor string `json:"c"`
+	Offset int    `json:"o"` // How many results come before the page. The leaderboards rank by it.
+}
+
+// Decodes a page token from a URL for paging through scope. An empty token is the first page.
+func decodePageToken(s, scope string) (*pageToken, error) {
+	token := &pageToken{Scope: scope}
+	if len(s) == 0 {
+		return token, nil
+	}
+
+	raw, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, token); err != nil {
+		return nil, err
+	}
+	if token.Scope != scope {
+		return nil, errors.New("the token is for paging through something else")
+	}
+	if token.Offset < 0 {
+		return nil, errors.New("negative offset")
+	}
+	if len(token.Cursor) == 0 && token.Offset > maxPageSkip {
+		return nil, errors.New("too many results to skip")
+	}
+	if len(token.Cursor) > 0 {
+		if _, err := datastore.DecodeCursor(token.Cursor); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// Gets the page token for paging through scope from the request, falling back to the first page if it's invalid.
+func getPageToken(c *Context, scope string) *pageToken {
+	token, err := decodePageToken(c.Req.FormValue("page"), scope)
+	if err != nil {
+		c.Warningf("Invalid page token: %s (%s)", c.Req.FormValue("page"), err)
+		return &pageToken{Scope: scope}
+	}
+	return token
+}
+
+// Returns the token for the page before this one, which has limit results on it.
+// It returns nil if this is the first page, or if the page before it is too far in to skip to.
+func (t *pageToken) previous(limit int) *pageToken {
+	if t.Offset == 0 {
+		return nil
+	}
+	offset := t.Offset - limit
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > maxPageSkip {
+		return nil
+	}
+	return &pageToken{Scope: t.Scope, Offset: offset}
+}
+
+func (t *pageToken) String() string {
+	raw, err := json.Marshal(t)
+	if err != nil {
+		panic(err)
+	}
+	return base64.URLEncoding.EncodeToString(raw)
+}
+
+// Runs a query from the start of a page, loading up to limit results into dst(0), dst(1) and so on, and returns how many there were.
+// The query should end its order with __key__ so that results with the same values keep their places between pages.
+// Pages without a cursor skip to their offset instead.
+func runPage(c *Context, q *datastore.Query, token *pageToken, limit int, dst func(i int) interface{}) (int, *pageToken, error) {
+	q = q.Limit(limit + 1)
+	if len(token.Cursor) > 0 {
+		cursor, err := datastore.DecodeCursor(token.Cursor)
+		if err != nil {
+			return 0, nil, err
+		}
+		q = q.Start(cursor)
+	} else if token.Offset > 0 {
+		q = q.Offset(token.Offset)
+	}
+
+	return readPage(c.Goon.Run(q), token, limit, dst)
+}
+
+type pageIterator interface {
+	Next(dst interface{}) (*datastore.Key, error)
+	Cursor() (datastore.Cursor, error)
+}
+
+// Reads a page of results for runPage. One more result than fits on the page is looked for,
+// so the token for the next page is only returned if there is a next page.
+func readPage(it pageIterator, token *pageToken, limit int, dst func(i int) interface{}) (int, *pageToken, error) {
+	for i := 0; i < limit; i++ {
+		if _, err := it.Next(dst(i)); err == datastore.Done {
+			return i, nil, nil
+		} else if err != nil {
+			return i, nil, err
+		}
+	}
+
+	cursor, err := it.Cursor()
+	if err != nil {
+		return limit, nil, err
+	}
+	if _, err := it.Next(nil); err == datastore.Done {
+		return limit, nil, nil
+	} else if err != nil {
+		return limit, nil, err
+	}
+	return limit, &pageToken{Scope: token.Scope, Cursor: cursor.String(), Offset: token.Offset + limit}, nil
+}
@@ -0,0 +1,120 @@
+package goapp
+
+import (
+	"appengine/datastore"
+	"encoding/base64"
+	"testing"
+)
+
+func TestDecodePageToken(t *testing.T) {
+	t.Parallel()
+
+	const scope = "runs/0//real"
+	token := &pageToken{Scope: scope, Offset: 20}
+	decoded, err := decodePageToken(token.String(), scope)
+	if err != nil {
+		t.Fatalf("Unable to decode %s: %s", token, err)
+	}
+	if *decoded != *token {
+		t.Errorf("Expected %+v, got %+v", token, decoded)
+	}
+
+	if first, err := decodePageToken("", scope); err != nil || *first != (pageToken{Scope: scope}) {
+		t.Errorf("Expected an empty token to be the first page, got %+v (%v)", first, err)
+	}
+
+	for name, s := range map[string]string{
+		"malformed":      "not a token!",
+		"not JSON":       base64.URLEncoding.EncodeToString([]byte("not JSON")),
+		"negative":       (&pageToken{Scope: scope, Offset: -10}).String(),
+		"too far":        (&pageToken{Scope: scope, Offset: maxPageSkip + 1}).String(),
+		"other game":     (&pageToken{Scope: "runs/1//real", Offset: 10}).String(),
+		"other category": (&pageToken{Scope: "runs/0/100/real", Offset: 10}).String(),
+	} {
+		if token, err := decodePageToken(s, scope); err == nil {
+			t.Errorf("%s: expected an error, got %+v", name, token)
+		}
+	}
+}
+
+func TestPreviousPage(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		offset, expected int
+		none             bool
+	}{
+		{offset: 0, none: true},
+		{offset: 5, expected: 0},
+		{offset: 10, expected: 0},
+		{offset: 30, expected: 20},
+		{offset: maxPageSkip + 10, expected: maxPageSkip},
+		{offset: maxPageSkip + 11, none: true},
+	} {
+		previous := (&pageToken{Scope: "scope", Cursor: "cursor", Offset: test.offset}).previous(10)
+		if test.none {
+			if previous != nil {
+				t.Errorf("Offset %d: expected no previous page, got %+v", test.offset, previous)
+			}
+		} else if previous == nil || *previous != (pageToken{Scope: "scope", Offset: test.expected}) {
+			t.Errorf("Offset %d: expected the previous page to start at %d, got %+v", test.offset, test.expected, previous)
+		}
+	}
+}
+
+// Iterates over a number of results without a datastore.
+type testIterator struct {
+	results, read int
+}
+
+func (it *testIterator) Next(dst interface{}) (*datastore.Key, error) {
+	if it.read == it.results {
+		return nil, datastore.Done
+	}
+	if dst != nil {
+		*dst.(*int) = it.read
+	}
+	it.read++
+	return nil, nil
+}
+
+func (it *testIterator) Cursor() (datastore.Cursor, error) {
+	return datastore.Cursor{}, nil
+}
+
+func TestReadPage(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		results  int
+		expected int
+		hasNext  bool
+	}{
+		{"empty", 0, 0, false},
+		{"partly full", 3, 3, false},
+		{"exactly full", 10, 10, false},
+		{"more to come", 11, 10, true},
+	} {
+		page := make([]int, 10)
+		token := &pageToken{Scope: "scope", Offset: 20}
+		n, next, err := readPage(&testIterator{results: test.results}, token, len(page), func(i int) interface{} { return &page[i] })
+		if err != nil {
+			t.Errorf("%s: unable to read page: %s", test.name, err)
+			continue
+		}
+		if n != test.expected {
+			t.Errorf("%s: expected %d results, got %d", test.name, test.expected, n)
+		}
+		for i := 0; i < n; i++ {
+			if page[i] != i {
+				t.Errorf("%s: expected result %d at %d, got %d", test.name, i, i, page[i])
+			}
+		}
+		if !test.hasNext && next != nil {
+			t.Errorf("%s: expected no next page, got %+v", test.name, next)
+		} else if test.hasNext && (next == nil || next.Scope != token.Scope || next.Offset != 30) {
+			t.Errorf("%s: expected the next page to start at 30, got %+v", test.name, next)
+		}
+	}
+}
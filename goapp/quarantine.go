@@ -0,0 +1,110 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine"
+	"appengine/blobstore"
+	"appengine/datastore"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/models"
+)
+
+const (
+	quarantineRetention = 30 * 24 * time.Hour
+	purgeBatchSize      = 100
+)
+
+// Takes a quarantined run out of quarantine and forgets its file, returning whether it did and the file so that it can be deleted.
+// The run is fetched again in a transaction so that nothing that changed it since it was queried is overwritten.
+// Runs that are waiting to be analyzed again keep their files, and so do runs that are no longer due to be purged.
+func unquarantineRun(c *Context, runKey *datastore.Key, cutoff time.Time) (bool, appengine.BlobKey, error) {
+	var (
+		purged  bool
+		runFile appengine.BlobKey
+	)
+	err := c.RunInTransaction(func(c *Context) error {
+		purged, runFile = false, ""
+
+		run := &models.Run{ID: runKey.IntID(), User: runKey.Parent()}
+		if err := c.Goon.Get(run); err != nil {
+			return err
+		}
+		if !run.Quarantined || run.AnalysisPending || !run.QuarantineTime.Before(cutoff) {
+			return nil
+		}
+
+		purged, runFile = true, run.RunFile
+		run.RunFile, run.Quarantined = "", false
+		_, err := c.Goon.Put(run)
+		return err
+	}, nil)
+	return purged && err == nil, runFile, err
+}
+
+// Deletes the files of a batch of quarantined runs and takes them out of quarantine, returning how many were purged.
+// The files are only deleted once the runs no longer refer to them.
+func purgeRuns(c *Context, runKeys []*datastore.Key, cutoff time.Time) int {
+	runFiles := make([]appengine.BlobKey, 0, len(runKeys))
+	purged := 0
+	c.Step("purge run files", func(c *Context) {
+		for _, runKey := range runKeys {
+			ok, runFile, err := unquarantineRun(c, runKey, cutoff)
+			if err != nil {
+				c.Errorf("Unable to purge run %s: %s", runKey.Encode(), err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			if len(runFile) > 0 {
+				runFiles = append(runFiles, runFile)
+			}
+			purged++
+		}
+
+		if len(runFiles) > 0 {
+			if err := blobstore.DeleteMulti(c, runFiles); err != nil {
+				panic(err)
+			}
+		}
+	})
+	return purged
+}
+
+// Deletes the files of quarantined runs once they've been kept for longer than the retention period.
+// This is run by cron.
+func PurgeQuarantine(c *Context) {
+	cutoff := time.Now().Add(-quarantineRetention)
+	q := datastore.NewQuery("Run").Filter("Quarantined =", true).Filter("QuarantineTime <", cutoff).KeysOnly()
+
+	purged := 0
+	batch := make([]*datastore.Key, 0, purgeBatchSize)
+	for it := c.Goon.Run(q); ; {
+		runKey, err := it.Next(nil)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+
+		batch = append(batch, runKey)
+		if len(batch) == purgeBatchSize {
+			purged += purgeRuns(c, batch, cutoff)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		purged += purgeRuns(c, batch, cutoff)
+	}
+
+	c.Infof("Purged %d quarantined runs", purged)
+	if _, err := io.WriteString(c.Response, fmt.Sprintf("Purged %d quarantined runs.", purged)); err != nil {
+		panic(err)
+	}
+}
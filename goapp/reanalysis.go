@@ -0,0 +1,248 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine/datastore"
+	"appengine/taskqueue"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/analysis"
+	"github.com/HL2-Ghosting-Team/website/models"
+)
+
+const (
+	reanalysisBatchSize = 50
+	reanalysisJobsShown = 10
+)
+
+// Queues a run to be analyzed again if its analysis was produced by an older version of the analysis, and reports whether it was.
+// Runs that haven't been analyzed yet are left alone, since they'll be analyzed by the current version anyway.
+func queueIfStale(c *Context, runKey *datastore.Key) (bool, error) {
+	queued := false
+	err := c.RunInTransaction(func(c *Context) error {
+		queued = false
+
+		run := &models.Run{ID: runKey.IntID(), User: runKey.Parent()}
+		if err := c.Goon.Get(run); err != nil {
+			return err
+		}
+		if run.Deleted || run.DeadLettered || run.AnalysisPending || run.FullAnalysis == nil || len(run.RunFile) == 0 {
+			return nil
+		}
+
+		fullAnalysis := &models.Analysis{ID: run.FullAnalysis.IntID(), Run: runKey}
+		if err := c.Goon.Get(fullAnalysis); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if fullAnalysis.AnalyzerVersion >= analysis.Version {
+			return nil
+		}
+
+		queued = true
+		return queueAnalysis(c, run, false)
+	}, nil)
+	return queued, err
+}
+
+// Adds a task for the next batch of a re-analysis job. This should be called in the transaction that stores the job.
+func queueReanalysisBatch(c *Context, job *models.ReanalysisJob) error {
+	taskURL, err := routerUrl("task-reanalyze-runs")
+	if err != nil {
+		return err
+	}
+
+	taskValues := make(url.Values)
+	taskValues.Set("job", c.Goon.Key(job).Encode())
+	taskValues.Set("batch", strconv.Itoa(job.Batches))
+	_, err = taskqueue.Add(c, taskqueue.NewPOSTTask(taskURL, taskValues), "reanalysis")
+	return err
+}
+
+// Queues a batch of runs to be analyzed again, then queues the next batch.
+// Every batch is numbered, so a batch that's run twice by the task queue doesn't fork the job.
+func ReanalyzeRuns(c *Context) {
+	jobIDstr := c.Req.FormValue("job")
+	jobKey, err := datastore.DecodeKey(jobIDstr)
+	if err != nil {
+		c.Infof("Unable to decode job key (%s): %s", jobIDstr, err)
+		http.Error(c.Response, "Unable to decode job key: "+jobIDstr, http.StatusBadRequest)
+		return
+	}
+	batch, err := strconv.Atoi(c.Req.FormValue("batch"))
+	if err != nil {
+		http.Error(c.Response, "Invalid batch: "+c.Req.FormValue("batch"), http.StatusBadRequest)
+		return
+	}
+
+	job := &models.ReanalysisJob{ID: jobKey.IntID()}
+	stop := false // TODO: Make this feel less hacky
+	c.Step("fetch job", func(c *Context) {
+		if err := c.Goon.Get(job); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				NotFound(c)
+				stop = true
+				return
+			}
+			panic(err)
+		}
+	})
+	if stop {
+		return
+	}
+
+	if job.Done || job.Batches != batch {
+		c.Warningf("Batch %d has already been done.", batch)
+		if _, err := io.WriteString(c.Response, "Batch already done."); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	scanned, queued := 0, 0
+	var cursor datastore.Cursor
+	c.Step("queue stale runs", func(c *Context) {
+		q := datastore.NewQuery("Run").KeysOnly().Limit(reanalysisBatchSize)
+		if job.Cursor != "" {
+			start, err := datastore.DecodeCursor(job.Cursor)
+			if err != nil {
+				panic(err)
+			}
+			q = q.Start(start)
+		}
+
+		it := q.Run(c)
+		for {
+			runKey, err := it.Next(nil)
+			if err == datastore.Done {
+				break
+			} else if err != nil {
+				panic(err)
+			}
+
+			scanned++
+			if stale, err := queueIfStale(c, runKey); err != nil {
+				panic(err)
+			} else if stale {
+				queued++
+			}
+		}
+
+		if cursor, err = it.Cursor(); err != nil {
+			panic(err)
+		}
+	})
+
+	c.Step("update job", func(c *Context) {
+		if err := c.RunInTransaction(func(c *Context) error {
+			job.Cursor = cursor.String()
+			job.Batches++
+			job.RunsScanned += scanned
+			job.RunsQueued += queued
+			if scanned < reanalysisBatchSize {
+				job.Done, job.FinishTime = true, time.Now()
+			}
+			if _, err := c.Goon.Put(job); err != nil {
+				return err
+			}
+
+			if job.Done {
+				return nil
+			}
+			return queueReanalysisBatch(c, job)
+		}, nil); err != nil {
+			panic(err)
+		}
+	})
+
+	c.Infof("Batch %d: scanned %d runs and queued %d to be analyzed again", batch, scanned, queued)
+	if _, err := io.WriteString(c.Response, fmt.Sprintf("Scanned %d runs, queued %d.", scanned, queued)); err != nil {
+		panic(err)
+	}
+}
+
+// Shows how far the runs are from being analyzed by the current version of the analysis, and the latest re-analysis jobs.
+func AdminReanalysis(c *Context) {
+	if !requireAdmin(c) {
+		return
+	}
+
+	jobsChan := make(chan []*models.ReanalysisJob, 1)
+	go c.Step("fetch jobs", func(c *Context) {
+		jobs := make([]*models.ReanalysisJob, 0, reanalysisJobsShown)
+		if _, err := c.Goon.GetAll(datastore.NewQuery("ReanalysisJob").Order("-StartTime").Limit(reanalysisJobsShown), &jobs); err != nil {
+			panic(err)
+		}
+		jobsChan <- jobs
+	})
+
+	c.Step("count analyses", func(c *Context) {
+		total, err := c.Goon.Count(datastore.NewQuery("Analysis"))
+		if err != nil {
+			panic(err)
+		}
+		upToDate, err := c.Goon.Count(datastore.NewQuery("Analysis").Filter("AnalyzerVersion =", analysis.Version))
+		if err != nil {
+			panic(err)
+		}
+
+		c.FillRenderParams(map[string]interface{}{
+			"TotalAnalyses":    total,
+			"UpToDateAnalyses": upToDate,
+		})
+	})
+
+	jobs := <-jobsChan
+	running := false
+	for _, job := range jobs {
+		if !job.Done {
+			running = true
+		}
+	}
+
+	c.FillRenderParams(map[string]interface{}{
+		"Jobs":            jobs,
+		"Running":         running,
+		"AnalyzerVersion": analysis.Version,
+	})
+
+	c.Render()
+}
+
+// Starts a job that analyzes every run whose analysis is out of date again.
+func AdminReanalysisPOST(c *Context) {
+	if !requireAdmin(c) {
+		return
+	}
+
+	job := &models.ReanalysisJob{
+		AnalyzerVersion: analysis.Version,
+		StartedBy:       c.CurrentUser().ID,
+		StartTime:       time.Now(),
+	}
+	c.Step("start job", func(c *Context) {
+		if err := c.RunInTransaction(func(c *Context) error {
+			if _, err := c.Goon.Put(job); err != nil {
+				return err
+			}
+			return queueReanalysisBatch(c, job)
+		}, nil); err != nil {
+			panic(err)
+		}
+	})
+	c.Infof("Administrator %s started re-analysis job %d", job.StartedBy, job.ID)
+
+	adminURL, err := routerUrl("admin-reanalysis")
+	if err != nil {
+		panic(err)
+	}
+
+	http.Redirect(c.Response, c.Req, adminURL, http.StatusSeeOther)
+}
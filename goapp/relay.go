@@ -0,0 +1,163 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine/datastore"
+	"net/http"
+	"strconv"
+
+	"github.com/HL2-Ghosting-Team/website/analysis"
+	"github.com/HL2-Ghosting-Team/website/models"
+)
+
+type legInternal struct {
+	analysis.Leg
+	FirstMapName string
+	LastMapName  string
+
+	Runner    *models.User // The user credited with the leg, if the run is a relay.
+	RunnerKey *datastore.Key
+}
+
+// Splits a run into the legs played by each runner, for the run page.
+func exposeLegs(c *Context, run *models.Run, result *analysis.Result) []*legInternal {
+	var runners []*models.User
+	if run.Relay {
+		c.Step("fetch runners", func(c *Context) {
+			runners = fetchUsers(c, run.Runners)
+		})
+	}
+
+	legs := make([]*legInternal, len(result.Legs))
+	for i, leg := range result.Legs {
+		legs[i] = &legInternal{Leg: leg}
+		if leg.FirstMap < len(result.Maps) {
+			legs[i].FirstMapName = result.Maps[leg.FirstMap].Name
+		}
+		if leg.LastMap < len(result.Maps) {
+			legs[i].LastMapName = result.Maps[leg.LastMap].Name
+		}
+		if i < len(runners) && runners[i] != nil {
+			legs[i].Runner = runners[i]
+			if len(runners[i].ID) > 0 { // Deleted users don't have a page.
+				legs[i].RunnerKey = c.Goon.Key(runners[i])
+			}
+		}
+	}
+	return legs
+}
+
+// Looks up the user with a nickname. It returns nil if there's nobody, or more than one user, with that nickname.
+func findUserByNickname(c *Context, nickname string) (*datastore.Key, error) {
+	keys, err := datastore.NewQuery("User").Filter("Nickname =", nickname).KeysOnly().Limit(2).GetAll(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 {
+		return nil, nil
+	}
+	return keys[0], nil
+}
+
+// Marks a run as a relay, or not, and credits each of its legs to the user named in the form.
+// The uploader can only credit themselves. Crediting anyone else takes a verifier, so that nobody is credited with a run they didn't play.
+func setRelay(c *Context, run *models.Run, currentUser *models.User) {
+	runKey := c.Goon.Key(run)
+	if run.Deleted || run.FullAnalysis == nil {
+		http.Error(c.Response, "This run hasn't been analyzed.", http.StatusBadRequest)
+		return
+	}
+
+	fullAnalysis := &models.Analysis{ID: run.FullAnalysis.IntID(), Run: runKey}
+	c.Step("fetch analysis", func(c *Context) {
+		if err := c.Goon.Get(fullAnalysis); err != nil {
+			panic(err)
+		}
+	})
+	if fullAnalysis.Fail {
+		http.Error(c.Response, "This run failed to be analyzed.", http.StatusBadRequest)
+		return
+	}
+
+	relay := c.Req.PostFormValue("relay") != ""
+	var runners []string
+	if relay {
+		runners = make([]string, len(fullAnalysis.Legs))
+		for i := range fullAnalysis.Legs {
+			nickname := c.Req.PostFormValue("runner_" + strconv.Itoa(i))
+			if len(nickname) == 0 {
+				continue
+			}
+
+			var runnerKey *datastore.Key
+			c.Step("find runner", func(c *Context) {
+				var err error
+				if runnerKey, err = findUserByNickname(c, nickname); err != nil {
+					panic(err)
+				}
+			})
+			if runnerKey == nil {
+				http.Error(c.Response, "There isn't exactly one user called "+nickname+".", http.StatusBadRequest)
+				return
+			}
+			runners[i] = runnerKey.StringID()
+		}
+	}
+
+	// The run may have been analyzed again since the form was shown, so the legs are checked against its current analysis.
+	changed, forbidden := false, false
+	c.Step("update run", func(c *Context) {
+		if err := c.RunInTransaction(func(c *Context) error {
+			current := &models.Run{ID: run.ID, User: run.User}
+			if err := c.Goon.Get(current); err != nil {
+				return err
+			}
+			if changed = current.Deleted || current.FullAnalysis == nil; changed {
+				return nil
+			}
+			currentAnalysis := &models.Analysis{ID: current.FullAnalysis.IntID(), Run: runKey}
+			if err := c.Goon.Get(currentAnalysis); err != nil {
+				return err
+			}
+			if changed = currentAnalysis.Fail || len(currentAnalysis.Legs) != len(fullAnalysis.Legs); changed {
+				return nil
+			}
+
+			if !currentUser.CanVerify() {
+				for i, runner := range runners {
+					alreadyCredited := i < len(current.Runners) && current.Runners[i] == runner
+					if forbidden = len(runner) > 0 && runner != currentUser.ID && !alreadyCredited; forbidden {
+						return nil
+					}
+				}
+			}
+
+			current.Relay, current.Runners = relay, runners
+			if _, err := c.Goon.Put(current); err != nil {
+				return err
+			}
+			*run = *current
+			return nil
+		}, nil); err != nil {
+			panic(err)
+		}
+	})
+	if changed {
+		http.Error(c.Response, "The run changed while its runners were being saved. Try again.", http.StatusConflict)
+		return
+	}
+	if forbidden {
+		http.Error(c.Response, "Only a verifier can credit other users with a leg.", http.StatusForbidden)
+		return
+	}
+	c.Infof("Run %s is a relay: %t, runners %q", runKey.Encode(), relay, runners)
+
+	runURL, err := routerUrl("view-run", runKey.Encode())
+	if err != nil {
+		panic(err)
+	}
+	http.Redirect(c.Response, c.Req, runURL, http.StatusSeeOther)
+}
@@ -1,734 +1,1120 @@
-// Copyright 2009 Michael Johnson. All rights reserved.
-// Use of this source code is governed by the MIT
-// license that can be found in the LICENSE file.
-
-package goapp
-
-import (
-	"appengine"
-	"appengine/blobstore"
-	"appengine/datastore"
-	"appengine/mail"
-	"appengine/taskqueue"
-	"appengine/user"
-	"fmt"
-	"html/template"
-	"io"
-	"net/http"
-	"net/url"
-	"strconv"
-	"strings"
-	"time"
-
-	"github.com/codegangsta/martini"
-	"github.com/nightexcessive/bytesize"
-
-	"github.com/HL2-Ghosting-Team/website/models"
-)
-
-const (
-	runsPerPage = 10
-	maxRunSize  = 4 * bytesize.MB
-)
-
-var (
-	top10Query = datastore.NewQuery("Run").Order("TotalTime").Project("TotalTime", "UploadTime").Filter("Ranked =", true).Filter("Deleted =", false).Filter("TotalTime >", 0).Limit(runsPerPage) // Get the top 10 runs for this game
-)
-
-func getGameName(c *Context) byte {
-	if requestedGame := c.Req.FormValue("game"); len(requestedGame) > 0 {
-		for gameID, gameName := range models.PrettyGameNames {
-			if gameName == requestedGame {
-				return gameID
-			}
-		}
-	}
-
-	return models.DefaultGame
-}
-
-type exposedRun struct {
-	Rank   int
-	Run    *models.Run
-	RunKey string
-	User   *models.User
-}
-
-type pagination struct {
-	Current    int
-	Next, Prev int
-	HasPrev    bool
-}
-
-func Runs(c *Context) {
-	var (
-		game = getGameName(c)
-		page = 0
-	)
-	if pageStr := c.Req.URL.Query().Get("page"); len(pageStr) > 0 {
-		page64, err := strconv.ParseInt(pageStr, 10, 32)
-		if err != nil {
-			c.Warningf("Invalid page: %s (%s)", pageStr, err)
-		} else {
-			page = int(page64)
-		}
-	}
-
-	runChannel := make(chan *exposedRun, runsPerPage)
-	go c.Step("fetch runs", func(c *Context) {
-		defer close(runChannel)
-
-		runs := make([]models.Run, 0, runsPerPage) // TODO: We can't use []*models.Run because goon will hate us. Find a fix for this.
-		c.Step("run query", func(c *Context) {
-			q := top10Query.Offset(page*runsPerPage).Filter("Game =", int(game))
-
-			if _, err := c.Goon.GetAll(q, &runs); err != nil {
-				panic(err)
-			}
-		})
-
-		users := make([]*models.User, len(runs))
-		c.Step("fetch uploaders", func(c *Context) {
-			for i, run := range runs {
-				users[i] = &models.User{
-					ID: run.User.StringID(),
-				}
-			}
-			if err := c.Goon.GetMulti(users); err != nil {
-				panic(err)
-			}
-		})
-		// Fetch all of the users
-
-		for i := range runs {
-			run := &runs[i]
-			runChannel <- &exposedRun{
-				Rank:   (page * runsPerPage) + i + 1,
-				Run:    run,
-				RunKey: c.Goon.Key(run).Encode(),
-				User:   users[i],
-			}
-		}
-	})
-
-	c.SetRenderParam("Game", game)
-	c.SetRenderParam("GameNames", models.PrettyGameNames)
-
-	exposedRuns := make([]*exposedRun, 0, runsPerPage)
-	for run := range runChannel {
-		exposedRuns = append(exposedRuns, run)
-	}
-	c.SetRenderParam("Runs", exposedRuns)
-
-	p := new(pagination)
-	p.Current = page
-	if len(exposedRuns) == runsPerPage {
-		p.Next = page + 1
-	}
-	if page > 0 {
-		p.Prev = page - 1
-		p.HasPrev = true
-	}
-	c.SetRenderParam("Pages", p)
-
-	c.Render()
-}
-
-func UploadRun(c *Context) {
-	game := getGameName(c)
-	c.SetRenderParam("Game", game)
-	c.SetRenderParam("MaxRunSize", maxRunSize)
-
-	doneURL, err := routerUrl("upload-run-done")
-	if err != nil {
-		panic(err)
-	}
-
-	if uploadURL, err := blobstore.UploadURL(c, doneURL, &blobstore.UploadURLOptions{MaxUploadBytes: int64(maxRunSize), MaxUploadBytesPerBlob: int64(maxRunSize)}); err == nil {
-		c.SetRenderParam("UploadURL", uploadURL)
-	} else {
-		panic(err)
-	}
-
-	c.Render()
-}
-
-func UploadRunDone(c *Context) {
-	var (
-		blobs map[string][]*blobstore.BlobInfo
-		form  url.Values
-	)
-	c.Step("parse uploads", func(c *Context) {
-		var err error
-		blobs, form, err = blobstore.ParseUpload(c.Req)
-		if err != nil {
-			panic(err)
-		}
-	})
-
-	var runBlob *blobstore.BlobInfo
-	if runBlobs := blobs["run"]; len(runBlobs) > 0 {
-		runBlob = blobs["run"][0]
-	}
-
-	c.Step("remove unused blobs", func(c *Context) {
-		deleteBlobs := make([]appengine.BlobKey, 0)
-		for _, blobList := range blobs {
-			for _, blobInfo := range blobList {
-				if runBlob == nil || blobInfo.BlobKey != runBlob.BlobKey {
-					deleteBlobs = append(deleteBlobs, blobInfo.BlobKey)
-				}
-			}
-		}
-
-		if len(deleteBlobs) > 0 {
-			if err := blobstore.DeleteMulti(c, deleteBlobs); err != nil {
-				panic(err)
-			}
-		}
-	})
-
-	if runBlob == nil {
-		c.Infof("No files uploaded: %#v", blobs)
-		http.Redirect(c.Response, c.Req, "/runs/upload", http.StatusSeeOther) // TODO: Improve this
-		return
-	}
-
-	u := user.Current(c)
-
-	var (
-		run    *models.Run
-		runKey *datastore.Key
-	)
-
-	c.Step("insert run", func(c *Context) {
-		if err := c.RunInTransaction(func(c *Context) error {
-			run = &models.Run{
-				User:       datastore.NewKey(c, "User", u.ID, 0, nil),
-				UploadTime: time.Now(),
-
-				Game: -1,
-
-				RunFile: runBlob.BlobKey,
-			}
-			if _, err := c.Goon.Put(run); err != nil {
-				return err
-			}
-			runKey = c.Goon.Key(run)
-
-			taskURL, err := routerUrl("task-process-run")
-			if err != nil {
-				return err
-			}
-
-			taskValues := make(url.Values)
-			taskValues.Set("id", runKey.Encode())
-			task := taskqueue.NewPOSTTask(taskURL, taskValues)
-			task.Name = runKey.Encode()
-			if _, err := taskqueue.Add(c, task, "runs"); err != nil {
-				return err
-			}
-
-			return nil
-		}, nil); err != nil {
-			panic(err)
-		}
-	})
-
-	runURL, err := routerUrl("view-run", runKey.Encode())
-	if err != nil {
-		panic(err)
-	}
-
-	http.Redirect(c.Response, c.Req, runURL, http.StatusSeeOther)
-}
-
-func ViewRun(c *Context, params martini.Params) {
-	runIDstr := params["id"]
-	runKey, err := datastore.DecodeKey(runIDstr)
-	if err != nil {
-		c.Infof("Unable to decode run key: %s", err)
-		http.Error(c.Response, "Invalid run ID: "+runIDstr, http.StatusBadRequest)
-		return
-	}
-	c.Infof("Run ID: %d", runKey.IntID())
-
-	run := &models.Run{ID: runKey.IntID(), User: runKey.Parent()}
-	stop := false // TODO: Make this feel less hacky
-	c.Step("fetch run", func(c *Context) {
-		if err := c.Goon.Get(run); err != nil {
-			if err == datastore.ErrNoSuchEntity {
-				NotFound(c)
-				stop = true
-				return
-			}
-			panic(err)
-		}
-	})
-	if stop {
-		return
-	}
-
-	c.SetRenderParam("Run", run)
-	c.SetRenderParam("RunKey", c.Goon.Key(run))
-
-	var uploader *models.User
-
-	if currentUserInterface, ok := c.GetRenderParam("User"); ok {
-		if currentUser, ok := currentUserInterface.(*models.User); ok {
-			if currentUser.ID == run.User.StringID() {
-				uploader = currentUser
-			}
-		}
-	}
-
-	if uploader == nil {
-		uploader = &models.User{ID: run.User.StringID()}
-		c.Step("fetch run uploader", func(c *Context) {
-			if err := c.Goon.Get(uploader); err != nil {
-				if err == datastore.ErrNoSuchEntity {
-					uploader = models.CreateDeletedUser()
-					return
-				}
-				panic(err)
-			}
-		})
-	}
-
-	c.SetRenderParam("Uploader", uploader)
-	c.SetRenderParam("UploaderKey", c.Goon.Key(uploader))
-
-	if !run.Deleted && run.FullAnalysis == nil {
-		c.SetRenderParam("ExtraHead", template.HTML("<meta http-equiv=\"refresh\" content=\"3\"/>"))
-	} else if run.FullAnalysis != nil {
-		c.Step("fetch full analysis", func(c *Context) {
-			analysis := &models.Analysis{ID: run.FullAnalysis.IntID(), Run: c.Goon.Key(run)}
-			if err := c.Goon.Get(analysis); err == datastore.ErrNoSuchEntity {
-				c.GlobalWG.Add(1)
-				go c.Step("update invalid analysis", func(c *Context) {
-					defer c.GlobalWG.Done()
-
-					run.FullAnalysis = nil
-					if _, err := c.Goon.Put(run); err != nil {
-						panic(err)
-					}
-				})
-				return
-			} else if err != nil {
-				panic(err)
-			}
-			analysis.MakeHeader()
-			c.SetRenderParam("FullAnalysis", analysis)
-			if numPlayers := len(analysis.Players); numPlayers == 0 {
-				c.SetRenderParam("PlayerStatement", "There were no players involved.")
-			} else if numPlayers == 1 {
-				c.SetRenderParam("PlayerStatement", analysis.Players[0]+" was the runner.")
-			} else {
-				statement := ""
-				for i, player := range analysis.Players {
-					if i-1 != numPlayers {
-						statement += player + ", "
-					} else {
-						statement += "and " + player + " "
-					}
-				}
-
-				statement += "were involved."
-
-				c.SetRenderParam("PlayerStatement", statement)
-			}
-		})
-	}
-	c.Render()
-}
-
-func DownloadRun(c *Context, params martini.Params) {
-	runIDstr := params["id"]
-	runKey, err := datastore.DecodeKey(runIDstr)
-	if err != nil {
-		c.Infof("Unable to decode run key: %s", err)
-		http.Error(c.Response, "Invalid run ID: "+runIDstr, http.StatusBadRequest)
-		return
-	}
-
-	run := &models.Run{ID: runKey.IntID(), User: runKey.Parent()}
-	stop := false // TODO: Make this feel less hacky
-	c.Step("fetch run", func(c *Context) {
-		if err := c.Goon.Get(run); err != nil {
-			if err == datastore.ErrNoSuchEntity {
-				NotFound(c)
-				stop = true
-				return
-			}
-			panic(err)
-		}
-	})
-	if stop {
-		return
-	}
-
-	headers := c.Response.Header()
-	headers.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v.run\"", run.ID))
-	headers.Set("Cache-Control", "public, max-age=2592000")
-	headers.Set("Pragma", "Public")
-
-	blobstore.Send(c.Response, run.RunFile)
-}
-
-func RunPOST(c *Context, params martini.Params) {
-	if err := c.Req.ParseForm(); err != nil {
-		panic(err)
-	}
-
-	runIDstr := params["id"]
-	runKey, err := datastore.DecodeKey(runIDstr)
-	if err != nil {
-		c.Infof("Unable to decode run key: %s", err)
-		http.Error(c.Response, "Invalid run ID: "+runIDstr, http.StatusBadRequest)
-		return
-	}
-
-	run := &models.Run{ID: runKey.IntID(), User: runKey.Parent()}
-	stop := false // TODO: Make this feel less hacky
-	c.Step("fetch run", func(c *Context) {
-		if err := c.Goon.Get(run); err != nil {
-			if err == datastore.ErrNoSuchEntity {
-				NotFound(c)
-				stop = true
-				return
-			}
-			panic(err)
-		}
-	})
-	if stop {
-		return
-	}
-
-	var currentUser *models.User
-	isUploader, isAdmin := false, false
-	c.IncludesWG.Wait()
-	if currentUserInterface, ok := c.GetRenderParam("User"); ok {
-		if currentUser_, ok := currentUserInterface.(*models.User); ok {
-			if currentUser_.ID == run.User.StringID() {
-				isUploader = true
-			}
-			if currentUser_.Admin {
-				isAdmin = true
-			}
-			currentUser = currentUser_
-		}
-	}
-
-	switch action := c.Req.PostFormValue("action"); action {
-	case "delete":
-		if isUploader {
-			analysis, runFile := run.FullAnalysis, run.RunFile
-			run.Deleted, run.RunFile, run.TotalTime, run.FullAnalysis = true, appengine.BlobKey(0), time.Duration(0), nil
-			if err := c.RunInTransaction(func(c *Context) error {
-				if _, err := c.Goon.Put(run); err != nil {
-					return err
-				}
-
-				if err := c.Goon.Delete(analysis); err != nil && err != datastore.ErrNoSuchEntity {
-					return err
-				}
-
-				if err := taskqueue.Delete(c, &taskqueue.Task{Name: runKey.Encode()}, "runs"); err != nil {
-					if !strings.HasSuffix(err.Error(), "(taskqueue: TOMBSTONED_TASK)") { // TODO: This is ridiculously hacky because Google doesn't provide us with any decent way of detecting these errors.
-						return err
-					}
-				}
-
-				if err := blobstore.Delete(c, runFile); err != nil {
-					return err
-				}
-
-				return nil
-			}, nil); err != nil {
-				panic(err)
-			}
-
-			runURL, err := routerUrl("view-run", runKey.Encode())
-			if err != nil {
-				panic(err)
-			}
-			http.Redirect(c.Response, c.Req, runURL, http.StatusSeeOther)
-		} else {
-			c.Infof("Attempted to delete a run that they weren't the owner of.")
-			http.Error(c.Response, "You do not own this run.", http.StatusForbidden)
-			return
-		}
-	case "admin_delete":
-		if isAdmin {
-			reason := c.Req.PostFormValue("reason")
-			if len(reason) <= 0 {
-				http.Error(c.Response, "A reason is required.", http.StatusBadRequest)
-			}
-
-			uploader := &models.User{
-				ID: run.User.StringID(),
-			}
-			c.Step("fetch uploader", func(c *Context) {
-				if err := c.Goon.Get(uploader); err != nil {
-					if err == datastore.ErrNoSuchEntity {
-						uploader = nil
-					}
-					panic(err)
-				}
-			})
-			if uploader == nil {
-				c.Warningf("Unable to fetch run uploader (%s): doesn't exist", run.User.StringID())
-			}
-
-			analysis, runFile := run.FullAnalysis, run.RunFile
-			run.Deleted, run.RunFile, run.TotalTime, run.FullAnalysis = true, appengine.BlobKey(0), time.Duration(0), nil
-			if err := c.RunInTransaction(func(c *Context) error {
-				if _, err := c.Goon.Put(run); err != nil {
-					return err
-				}
-
-				if err := c.Goon.Delete(analysis); err != nil && err != datastore.ErrNoSuchEntity {
-					return err
-				}
-
-				if err := taskqueue.Delete(c, &taskqueue.Task{Name: runKey.Encode()}, "runs"); err != nil {
-					if errStr := err.Error(); !strings.HasSuffix(errStr, "(taskqueue: TOMBSTONED_TASK)") && !strings.HasSuffix(errStr, "(taskqueue: UNKNOWN_TASK)") { // TODO: This is ridiculously hacky because Google doesn't provide us with any decent way of detecting these errors.
-						return err
-					}
-				}
-
-				if err := blobstore.Delete(c, runFile); err != nil {
-					return err
-				}
-
-				runURL, err := routerUrl("view-run", runKey.Encode())
-				if err != nil {
-					return err // TODO: Should we even bother returning an error here or should we just panic?
-				}
-				if parsedURL, err := c.Req.URL.Parse(runURL); err != nil {
-					return err
-				} else {
-					runURL = parsedURL.String()
-				}
-				if err := mail.SendToAdmins(c, &mail.Message{
-					Sender:  getAppEmail(c, "admin-team"),
-					Subject: fmt.Sprintf("Run %s deleted by %s", runKey.Encode(), currentUser.Nickname),
-
-					Body: fmt.Sprintf(`%s/(%s)/(%s) has deleted a run uploaded by (%s). The resides at %s.
-
-Reason given:
-%s`, currentUser.Nickname, currentUser.Email, currentUser.ID, run.User.StringID(), runURL, reason),
-				}); err != nil {
-					return err
-				}
-
-				if uploader != nil && len(uploader.Email) > 0 {
-					if err := mail.Send(c, &mail.Message{
-						Sender:  getAppEmail(c, "admin-team"),
-						To:      []string{uploader.Email},
-						Subject: fmt.Sprintf("Your run has been deleted"),
-
-						Body: fmt.Sprintf("Your run at %s has been deleted by an administrator. The reason given was:\n%s", runURL, reason),
-					}); err != nil {
-						c.Errorf("Error sending deletion email to user: %s", err)
-					}
-				} else {
-					c.Infof("Didn't send deletion mail to uploader: uploader doesn't exist or has no email address")
-				}
-
-				return nil
-			}, nil); err != nil {
-				panic(err)
-			}
-			c.Warningf("!!! ADMINISTRATOR %s DELETED RUN %s !!!\nREASON:\n%s", currentUser.ID, runKey.Encode(), reason)
-			http.Redirect(c.Response, c.Req, "/runs", http.StatusSeeOther)
-		} else {
-			c.Infof("Attempted to admin delete a run and they aren't an admin.")
-			http.Error(c.Response, "You must be an administrator to perform this action.", http.StatusForbidden)
-			return
-		}
-	default:
-		c.Infof("Unknown action: %s", action)
-		http.Error(c.Response, "Unknown action: "+action, http.StatusBadRequest)
-		return
-	}
-}
-
-func failedAnalysis(c *Context, run *models.Run, reason string) {
-	c.GlobalWG.Add(1)
-	go c.Step("insert failed analysis", func(c *Context) {
-		defer c.GlobalWG.Done()
-
-		analysis := &models.Analysis{
-			Run: c.Goon.Key(run),
-
-			Fail:       true,
-			FailReason: reason,
-		}
-
-		if err := c.RunInTransaction(func(c *Context) error {
-			if err := blobstore.Delete(c, run.RunFile); err != nil && err != datastore.ErrNoSuchEntity {
-				return err
-			}
-			run.RunFile = ""
-			if _, err := c.Goon.Put(analysis); err != nil {
-				return err
-			}
-			run.FullAnalysis = c.Goon.Key(analysis) // Unfortunately, we can't do a PutMulti because we need to know the key of Analysis.
-			if _, err := c.Goon.Put(run); err != nil {
-				return err
-			}
-
-			return nil
-		}, nil); err != nil {
-			panic(err)
-		}
-	})
-	c.Infof("Analysis failed: %s", reason)
-}
-
-func ProcessRun(c *Context) {
-	runIDstr := c.Req.FormValue("id")
-	runKey, err := datastore.DecodeKey(runIDstr)
-	if err != nil {
-		c.Infof("Unable to decode run key (%s): %s", runIDstr, err)
-		http.Error(c.Response, "Unable to decode run key: "+runIDstr, http.StatusBadRequest)
-		return
-	}
-
-	run := &models.Run{ID: runKey.IntID(), User: runKey.Parent()}
-	stop := false // TODO: Make this feel less hacky
-	c.Step("fetch run", func(c *Context) {
-		if err := c.Goon.Get(run); err != nil {
-			if err == datastore.ErrNoSuchEntity {
-				NotFound(c)
-				stop = true
-				return
-			}
-			panic(err)
-		}
-	})
-	if stop {
-		return
-	}
-
-	if run.FullAnalysis != nil {
-		c.Warningf("This run has already been analyzed.")
-		if _, err := io.WriteString(c.Response, "Run already analyzed."); err != nil {
-			panic(err)
-		}
-		return
-	}
-
-	blobReader := blobstore.NewReader(c, run.RunFile)
-	runReader := &models.RunReader{blobReader}
-
-	if verified, err := runReader.VerifyPreamble(); err != nil {
-		failedAnalysis(c, run, fmt.Sprintf("Failed to read the preamble (%s)", err))
-		return
-	} else if !verified {
-		failedAnalysis(c, run, "The given file is not a valid run file.")
-		return
-	}
-
-	header, err := runReader.ReadHeader()
-	if err != nil {
-		failedAnalysis(c, run, fmt.Sprintf("Failed to read the run header (%s)", err))
-		return
-	}
-
-	run.Game = int(header.Game)
-
-	c.Infof("Header: %#v", header)
-
-	lineNumber := 1
-
-	analysis := &models.Analysis{
-		Run: runKey,
-
-		Maps:      make([]models.MapAnalysis, 0),
-		Players:   make([]string, 1),
-		RawHeader: header.MakeRaw(),
-	}
-
-	c.Step("analyzing", func(c *Context) {
-		runLine, err := runReader.ReadLine()
-
-		currentMap := models.MapAnalysis{
-			Name: runLine.MapName,
-		}
-		currentMapStart := runLine.Time
-		c.Infof("Map name change: %q -> %q", "", currentMap.Name)
-
-		lastPlayerName := runLine.PlayerName
-		c.Infof("Player name change: %q -> %q", "", lastPlayerName)
-		analysis.Players[0] = lastPlayerName
-
-		lastLine := runLine
-		for ; err != io.EOF; runLine, err = runReader.ReadLine() {
-			if err != nil { // If the error isn't nil and we've made it here, it's an unexpected error
-				failedAnalysis(c, run, fmt.Sprintf("Failed to read line #%d (%s)", lineNumber, err))
-				return
-			}
-
-			if len(runLine.MapName) > 0 && currentMap.Name != runLine.MapName {
-				c.Infof("Map name change (%d): %q -> %q", lineNumber, currentMap.Name, runLine.MapName)
-
-				currentMap.Time = time.Duration((runLine.Time - currentMapStart) * float32(time.Second))
-				analysis.Maps = append(analysis.Maps, currentMap)
-
-				currentMap = models.MapAnalysis{
-					Name: runLine.MapName,
-				}
-				currentMapStart = runLine.Time
-			}
-
-			if len(runLine.PlayerName) > 0 && lastPlayerName != runLine.PlayerName {
-				c.Infof("Player name change (%d): %q -> %q", lineNumber, lastPlayerName, runLine.PlayerName)
-
-				found := false
-				for _, name := range analysis.Players {
-					if runLine.PlayerName == name {
-						found = true
-						break
-					}
-				}
-
-				if !found {
-					analysis.Players = append(analysis.Players, runLine.PlayerName)
-				}
-
-				lastPlayerName = runLine.PlayerName
-			}
-
-			lastLine = runLine
-			lineNumber++
-		}
-
-		currentMap.Time = time.Duration((lastLine.Time - currentMapStart) * float32(time.Second))
-		analysis.Maps = append(analysis.Maps, currentMap) // Insert the last map
-
-		run.TotalTime = time.Duration(lastLine.Time * float32(time.Second))
-	})
-
-	c.Step("insert analysis", func(c *Context) {
-		if err := c.RunInTransaction(func(c *Context) error {
-			if _, err := c.Goon.Put(analysis); err != nil {
-				return err
-			}
-			run.FullAnalysis = c.Goon.Key(analysis) // Unfortunately, we can't do a PutMulti because we need to know the key of Analysis.
-			if _, err := c.Goon.Put(run); err != nil {
-				return err
-			}
-
-			return nil
-		}, nil); err != nil {
-			panic(err)
-		}
-	})
-
-	c.Response.WriteHeader(http.StatusOK)
-	if _, err := io.WriteString(c.Response, "Successfully analyzed."); err != nil {
-		panic(err)
-	}
-}
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine"
+	"appengine/blobstore"
+	"appengine/datastore"
+	"appengine/mail"
+	"appengine/taskqueue"
+	"appengine/user"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/martini"
+	"github.com/nightexcessive/bytesize"
+
+	"github.com/HL2-Ghosting-Team/website/analysis"
+	"github.com/HL2-Ghosting-Team/website/models"
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+const (
+	runsPerPage = 10
+	maxRunSize  = 4 * bytesize.MB
+)
+
+var maxAnalysisAttempts = 5 // How many times a run is attempted before it's dead-lettered.
+
+// The ways that runs can be timed on the leaderboards, and the property of Run that each of them ranks by.
+var timings = map[string]string{
+	"real": "TotalTime",
+	"game": "GameTime",
+}
+
+const defaultTiming = "real"
+
+// Gets the runs on the leaderboard of a game's category in one of the timings. Each user only has their best run on it.
+// Games without categories have a single leaderboard, whose category is empty.
+// Deleted isn't indexed, so it can't be filtered on. Deleting a run unranks it instead.
+func leaderboardQuery(game byte, category, timing string) *datastore.Query {
+	property := timings[timing]
+	return datastore.NewQuery("Run").Filter("Ranked =", true).Filter("Game =", int(game)).Filter("Category =", category).Filter("BestTimings =", timing).Filter(property+" >", 0)
+}
+
+// Gets the top 10 runs for a game's category, ranked by one of the timings. Runs with the same time are kept in the same order so that they can be paged through.
+func top10Query(game byte, category, timing string) *datastore.Query {
+	return leaderboardQuery(game, category, timing).Order(timings[timing]).Order("__key__").Project("TotalTime", "GameTime", "UploadTime").Limit(runsPerPage)
+}
+
+func getTiming(c *Context) string {
+	if requestedTiming := c.Req.FormValue("timing"); len(requestedTiming) > 0 {
+		if _, ok := timings[requestedTiming]; ok {
+			return requestedTiming
+		}
+	}
+
+	return defaultTiming
+}
+
+func getGameName(c *Context) byte {
+	if requestedGame := c.Req.FormValue("game"); len(requestedGame) > 0 {
+		for gameID, gameName := range models.PrettyGameNames {
+			if gameName == requestedGame {
+				return gameID
+			}
+		}
+	}
+
+	return models.DefaultGame
+}
+
+type exposedRun struct {
+	Rank   int
+	Run    *models.Run
+	RunKey string
+	User   *models.User
+}
+
+func Runs(c *Context) {
+	var (
+		game       = getGameName(c)
+		timing     = getTiming(c)
+		next       *pageToken
+		categories []*models.Category
+	)
+
+	c.Step("fetch categories", func(c *Context) {
+		categories = fetchCategories(c, game)
+	})
+	category := getCategory(c, categories)
+	categoryID := ""
+	if category != nil {
+		categoryID = category.ID
+		if len(category.Timing) > 0 {
+			timing = category.Timing
+		}
+	}
+	page := getPageToken(c, fmt.Sprintf("runs/%d/%s/%s", game, categoryID, timing))
+
+	runChannel := make(chan *exposedRun, runsPerPage)
+	go c.Step("fetch runs", func(c *Context) {
+		defer close(runChannel)
+
+		runs := make([]models.Run, runsPerPage) // TODO: We can't use []*models.Run because goon will hate us. Find a fix for this.
+		c.Step("run query", func(c *Context) {
+			n, nextPage, err := runPage(c, top10Query(game, categoryID, timing), page, runsPerPage, func(i int) interface{} { return &runs[i] })
+			if err != nil {
+				panic(err)
+			}
+			runs, next = runs[:n], nextPage
+		})
+
+		users := make([]*models.User, len(runs))
+		c.Step("fetch uploaders", func(c *Context) {
+			for i, run := range runs {
+				users[i] = &models.User{
+					ID: run.User.StringID(),
+				}
+			}
+			if err := c.Goon.GetMulti(users); err != nil {
+				panic(err)
+			}
+		})
+		// Fetch all of the users
+
+		var ranks []int
+		c.Step("rank runs", func(c *Context) {
+			values := make([]time.Duration, len(runs))
+			for i := range runs {
+				values[i] = timingValue(timing, runs[i].TotalTime, runs[i].GameTime)
+			}
+
+			var err error
+			if ranks, err = rankTimes(values, page.Offset, func(value time.Duration) (int, error) {
+				return c.Goon.Count(leaderboardQuery(game, categoryID, timing).Filter(timings[timing]+" <", value))
+			}); err != nil {
+				panic(err)
+			}
+		})
+
+		for i := range runs {
+			run := &runs[i]
+			runChannel <- &exposedRun{
+				Rank:   ranks[i],
+				Run:    run,
+				RunKey: c.Goon.Key(run).Encode(),
+				User:   users[i],
+			}
+		}
+	})
+
+	c.SetRenderParam("Game", game)
+	c.SetRenderParam("GameNames", models.PrettyGameNames)
+	c.SetRenderParam("Timing", timing)
+	c.SetRenderParam("Categories", categories)
+	c.SetRenderParam("Category", category)
+	c.SetRenderParam("Uncategorized", uncategorized)
+
+	exposedRuns := make([]*exposedRun, 0, runsPerPage)
+	for run := range runChannel {
+		exposedRuns = append(exposedRuns, run)
+	}
+	c.SetRenderParam("Runs", exposedRuns)
+	c.SetRenderParam("FirstPage", page.Offset == 0)
+	c.SetRenderParam("PrevPage", page.previous(runsPerPage))
+	c.SetRenderParam("NextPage", next) // Read after the runs have all been sent, so the query has finished.
+
+	c.Render()
+}
+
+// Problems with an upload that are shown on the upload form. They're passed around as codes so that nobody can put their own message on the page.
+var uploadErrors = map[string]string{
+	"no_file":             "No run file was uploaded.",
+	"not_run_file":        "The uploaded file isn't a run file.",
+	"unsupported_version": "The uploaded run file uses a version of the run file format that this website doesn't support yet.",
+	"truncated_header":    "The uploaded run file ends before its header does.",
+	"unknown_game":        "The uploaded run file is for a game that this website doesn't support.",
+	"unknown_category":    "The chosen category doesn't exist.",
+	"wrong_category":      "The chosen category is for a different game than the uploaded run file.",
+	"no_category":         "The uploaded run file's game has categories, so one of them has to be chosen.",
+	"upload_failed":       "Something went wrong while storing your run. Please try uploading it again.",
+}
+
+// Sends the uploader back to the upload form, which will show them what was wrong with their upload.
+func uploadFailed(c *Context, code string) {
+	uploadURL, err := routerUrl("upload-run")
+	if err != nil {
+		panic(err)
+	}
+
+	http.Redirect(c.Response, c.Req, uploadURL+"?error="+url.QueryEscape(code), http.StatusSeeOther) // The blobstore requires upload handlers to redirect.
+}
+
+// Checks the preamble and header of an uploaded run so that obviously broken files can be turned away before anything is stored.
+// The category that the uploader chose, if any, has to be for the run's game. Games with categories need one to be chosen.
+// It returns the upload error code describing the problem, or an empty string if the run looks fine.
+// Problems that aren't the uploader's fault, such as the blobstore being unavailable, are logged and reported as upload_failed.
+func validateUpload(c *Context, blobKey appengine.BlobKey, categoryID string) string {
+	r := runfile.NewReader(blobstore.NewReader(c, blobKey))
+
+	_, err := r.VerifyPreamble()
+	var header *runfile.Header
+	if err == nil {
+		header, err = r.ReadHeader()
+	}
+
+	if decodeErr, ok := err.(*runfile.DecodeError); ok {
+		switch decodeErr.Err {
+		case runfile.ErrUnsupportedVersion:
+			return "unsupported_version"
+		case runfile.ErrTruncatedHeader:
+			return "truncated_header"
+		default:
+			return "not_run_file"
+		}
+	} else if err != nil {
+		c.Errorf("Unable to read the uploaded run: %s", err)
+		return "upload_failed"
+	}
+
+	if _, ok := models.PrettyGameNames[header.Game]; !ok {
+		return "unknown_game"
+	}
+
+	if len(categoryID) > 0 {
+		category, err := fetchCategory(c, categoryID)
+		if err != nil {
+			c.Errorf("Unable to fetch category %s: %s", categoryID, err)
+			return "upload_failed"
+		}
+		if category == nil {
+			return "unknown_category"
+		} else if category.Game != int(header.Game) {
+			return "wrong_category"
+		}
+	} else if count, err := c.Goon.Count(datastore.NewQuery("Category").Filter("Game =", int(header.Game)).KeysOnly()); err != nil {
+		c.Errorf("Unable to count the categories of game %d: %s", header.Game, err)
+		return "upload_failed"
+	} else if count > 0 {
+		return "no_category"
+	}
+
+	return ""
+}
+
+func UploadRun(c *Context) {
+	game := getGameName(c)
+	c.SetRenderParam("Game", game)
+	c.SetRenderParam("MaxRunSize", maxRunSize)
+	c.Step("fetch categories", func(c *Context) {
+		c.SetRenderParam("Categories", fetchAllCategories(c))
+	})
+
+	if errorCode := c.Req.URL.Query().Get("error"); len(errorCode) > 0 {
+		if message, ok := uploadErrors[errorCode]; ok {
+			c.SetRenderParam("UploadError", message)
+		}
+	}
+
+	doneURL, err := routerUrl("upload-run-done")
+	if err != nil {
+		panic(err)
+	}
+
+	if uploadURL, err := blobstore.UploadURL(c, doneURL, &blobstore.UploadURLOptions{MaxUploadBytes: int64(maxRunSize), MaxUploadBytesPerBlob: int64(maxRunSize)}); err == nil {
+		c.SetRenderParam("UploadURL", uploadURL)
+	} else {
+		panic(err)
+	}
+
+	c.Render()
+}
+
+func UploadRunDone(c *Context) {
+	var (
+		blobs      map[string][]*blobstore.BlobInfo
+		formValues url.Values
+	)
+	c.Step("parse uploads", func(c *Context) {
+		var err error
+		blobs, formValues, err = blobstore.ParseUpload(c.Req)
+		if err != nil {
+			panic(err)
+		}
+	})
+
+	var runBlob *blobstore.BlobInfo
+	if runBlobs := blobs["run"]; len(runBlobs) > 0 {
+		runBlob = blobs["run"][0]
+	}
+
+	c.Step("remove unused blobs", func(c *Context) {
+		deleteBlobs := make([]appengine.BlobKey, 0)
+		for _, blobList := range blobs {
+			for _, blobInfo := range blobList {
+				if runBlob == nil || blobInfo.BlobKey != runBlob.BlobKey {
+					deleteBlobs = append(deleteBlobs, blobInfo.BlobKey)
+				}
+			}
+		}
+
+		if len(deleteBlobs) > 0 {
+			if err := blobstore.DeleteMulti(c, deleteBlobs); err != nil {
+				c.Errorf("Unable to delete unused blobs: %s", err)
+			}
+		}
+	})
+
+	if runBlob == nil {
+		c.Infof("No files uploaded: %#v", blobs)
+		uploadFailed(c, "no_file")
+		return
+	}
+
+	var errorCode string
+	c.Step("validate run", func(c *Context) {
+		errorCode = validateUpload(c, runBlob.BlobKey, formValues.Get("category"))
+	})
+	if len(errorCode) > 0 {
+		c.Infof("Rejected upload (%s): %s", errorCode, uploadErrors[errorCode])
+		c.Step("remove rejected run", func(c *Context) {
+			if err := blobstore.Delete(c, runBlob.BlobKey); err != nil {
+				c.Errorf("Unable to delete the rejected run: %s", err)
+			}
+		})
+		uploadFailed(c, errorCode)
+		return
+	}
+
+	u := user.Current(c)
+
+	var (
+		run    *models.Run
+		runKey *datastore.Key
+	)
+
+	c.Step("insert run", func(c *Context) {
+		if err := c.RunInTransaction(func(c *Context) error {
+			run = &models.Run{
+				User:       datastore.NewKey(c, "User", u.ID, 0, nil),
+				UploadTime: time.Now(),
+
+				Game:            -1,
+				IndividualLevel: formValues.Get("individual_level") != "",
+				Category:        formValues.Get("category"),
+
+				RunFile: runBlob.BlobKey,
+			}
+			if err := queueAnalysis(c, run, true); err != nil {
+				return err
+			}
+			runKey = c.Goon.Key(run)
+
+			return nil
+		}, nil); err != nil {
+			c.Errorf("Unable to store the uploaded run: %s", err)
+			if err := blobstore.Delete(c, runBlob.BlobKey); err != nil {
+				c.Errorf("Unable to delete the run file: %s", err)
+			}
+			runKey = nil
+		}
+	})
+	if runKey == nil {
+		uploadFailed(c, "upload_failed")
+		return
+	}
+
+	runURL, err := routerUrl("view-run", runKey.Encode())
+	if err != nil {
+		panic(err)
+	}
+
+	http.Redirect(c.Response, c.Req, runURL, http.StatusSeeOther)
+}
+
+// Stores a run and adds a task to analyze it, starting over its count of analysis attempts. This should be called in a transaction.
+// Task names can't be reused, so only the first analysis of a run should be named.
+// If the run already has an analysis, it's replaced in place.
+func queueAnalysis(c *Context, run *models.Run, named bool) error {
+	run.AnalysisPending, run.AnalysisQueueTime = true, time.Now()
+	run.AnalysisAttempts, run.LastAnalysisError, run.DeadLettered = 0, "", false
+	if _, err := c.Goon.Put(run); err != nil {
+		return err
+	}
+	runKey := c.Goon.Key(run)
+
+	taskURL, err := routerUrl("task-process-run")
+	if err != nil {
+		return err
+	}
+
+	taskValues := make(url.Values)
+	taskValues.Set("id", runKey.Encode())
+	if run.FullAnalysis != nil {
+		taskValues.Set("reanalyze", "1")
+	}
+	task := taskqueue.NewPOSTTask(taskURL, taskValues)
+	if named {
+		task.Name = runKey.Encode()
+	}
+	_, err = taskqueue.Add(c, task, "runs")
+	return err
+}
+
+func ViewRun(c *Context, params martini.Params) {
+	runIDstr := params["id"]
+	runKey, err := datastore.DecodeKey(runIDstr)
+	if err != nil {
+		c.Infof("Unable to decode run key: %s", err)
+		http.Error(c.Response, "Invalid run ID: "+runIDstr, http.StatusBadRequest)
+		return
+	}
+	c.Infof("Run ID: %d", runKey.IntID())
+
+	run := &models.Run{ID: runKey.IntID(), User: runKey.Parent()}
+	stop := false // TODO: Make this feel less hacky
+	c.Step("fetch run", func(c *Context) {
+		if err := c.Goon.Get(run); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				NotFound(c)
+				stop = true
+				return
+			}
+			panic(err)
+		}
+	})
+	if stop {
+		return
+	}
+
+	c.SetRenderParam("Run", run)
+	c.SetRenderParam("RunKey", c.Goon.Key(run))
+	c.SetRenderParam("Uncategorized", uncategorized)
+
+	if len(run.Category) > 0 {
+		c.Step("fetch category", func(c *Context) {
+			category, err := fetchCategory(c, run.Category)
+			if err != nil {
+				panic(err)
+			}
+			c.SetRenderParam("Category", category)
+		})
+	}
+
+	var uploader *models.User
+
+	if currentUserInterface, ok := c.GetRenderParam("User"); ok {
+		if currentUser, ok := currentUserInterface.(*models.User); ok {
+			if currentUser.ID == run.User.StringID() {
+				uploader = currentUser
+			}
+		}
+	}
+
+	if uploader == nil {
+		uploader = &models.User{ID: run.User.StringID()}
+		c.Step("fetch run uploader", func(c *Context) {
+			if err := c.Goon.Get(uploader); err != nil {
+				if err == datastore.ErrNoSuchEntity {
+					uploader = models.CreateDeletedUser()
+					return
+				}
+				panic(err)
+			}
+		})
+	}
+
+	c.SetRenderParam("Uploader", uploader)
+	c.SetRenderParam("UploaderKey", c.Goon.Key(uploader))
+
+	canDownload := len(run.RunFile) > 0
+	if run.Quarantined {
+		currentUser := c.CurrentUser()
+		canDownload = canDownload && currentUser != nil && (currentUser.Admin || currentUser.ID == run.User.StringID())
+		c.SetRenderParam("QuarantineEnds", run.QuarantineTime.Add(quarantineRetention))
+	}
+	c.SetRenderParam("CanDownload", canDownload)
+
+	if currentUser := c.CurrentUser(); currentUser != nil && currentUser.CanVerify() {
+		c.SetRenderParam("CanVerify", true)
+	}
+	if run.VerificationState != models.VerificationNone {
+		c.Step("fetch verifications", func(c *Context) {
+			c.SetRenderParam("Verifications", fetchVerifications(c, c.Goon.Key(run)))
+		})
+	}
+	c.SetRenderParam("NeedsEvidence", run.VerificationState == models.VerificationNeedsEvidence)
+
+	if !run.Deleted && run.FullAnalysis == nil {
+		// A dead lettered run won't be analyzed until an administrator retries it, so there's nothing to wait for.
+		if run.AnalysisPending && !run.DeadLettered {
+			c.SetRenderParam("ExtraHead", template.HTML("<meta http-equiv=\"refresh\" content=\"3\"/>"))
+		}
+	} else if run.FullAnalysis != nil {
+		c.Step("fetch full analysis", func(c *Context) {
+			analysis := &models.Analysis{ID: run.FullAnalysis.IntID(), Run: c.Goon.Key(run)}
+			if err := c.Goon.Get(analysis); err == datastore.ErrNoSuchEntity {
+				c.GlobalWG.Add(1)
+				go c.Step("update invalid analysis", func(c *Context) {
+					defer c.GlobalWG.Done()
+
+					run.FullAnalysis = nil
+					if _, err := c.Goon.Put(run); err != nil {
+						panic(err)
+					}
+				})
+				return
+			} else if err != nil {
+				panic(err)
+			}
+			analysis.MakeHeader()
+			c.SetRenderParam("FullAnalysis", analysis)
+			if numPlayers := len(analysis.Players); numPlayers == 0 {
+				c.SetRenderParam("PlayerStatement", "There were no players involved.")
+			} else if numPlayers == 1 {
+				c.SetRenderParam("PlayerStatement", analysis.Players[0]+" was the runner.")
+			} else {
+				statement := strings.Join(analysis.Players[:numPlayers-1], ", ") + " and " + analysis.Players[numPlayers-1] + " were involved."
+
+				c.SetRenderParam("PlayerStatement", statement)
+			}
+			c.SetRenderParam("Legs", exposeLegs(c, run, &analysis.Result))
+		})
+	}
+	c.Render()
+}
+
+func DownloadRun(c *Context, params martini.Params) {
+	runIDstr := params["id"]
+	runKey, err := datastore.DecodeKey(runIDstr)
+	if err != nil {
+		c.Infof("Unable to decode run key: %s", err)
+		http.Error(c.Response, "Invalid run ID: "+runIDstr, http.StatusBadRequest)
+		return
+	}
+
+	run := &models.Run{ID: runKey.IntID(), User: runKey.Parent()}
+	stop := false // TODO: Make this feel less hacky
+	c.Step("fetch run", func(c *Context) {
+		if err := c.Goon.Get(run); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				NotFound(c)
+				stop = true
+				return
+			}
+			panic(err)
+		}
+	})
+	if stop {
+		return
+	}
+
+	if run.Deleted || len(run.RunFile) == 0 {
+		NotFound(c)
+		return
+	}
+
+	headers := c.Response.Header()
+	headers.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v.run\"", run.ID))
+	if run.Quarantined {
+		if currentUser := c.CurrentUser(); currentUser == nil || (!currentUser.Admin && currentUser.ID != run.User.StringID()) {
+			http.Error(c.Response, "This run file has been quarantined. Only its uploader and administrators can download it.", http.StatusForbidden)
+			return
+		}
+		headers.Set("Cache-Control", "private, no-cache")
+	} else {
+		headers.Set("Cache-Control", "public, max-age=2592000")
+		headers.Set("Pragma", "Public")
+	}
+
+	blobstore.Send(c.Response, run.RunFile)
+}
+
+func RunPOST(c *Context, params martini.Params) {
+	if err := c.Req.ParseForm(); err != nil {
+		panic(err)
+	}
+
+	runIDstr := params["id"]
+	runKey, err := datastore.DecodeKey(runIDstr)
+	if err != nil {
+		c.Infof("Unable to decode run key: %s", err)
+		http.Error(c.Response, "Invalid run ID: "+runIDstr, http.StatusBadRequest)
+		return
+	}
+
+	run := &models.Run{ID: runKey.IntID(), User: runKey.Parent()}
+	stop := false // TODO: Make this feel less hacky
+	c.Step("fetch run", func(c *Context) {
+		if err := c.Goon.Get(run); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				NotFound(c)
+				stop = true
+				return
+			}
+			panic(err)
+		}
+	})
+	if stop {
+		return
+	}
+
+	var currentUser *models.User
+	isUploader, isAdmin := false, false
+	c.IncludesWG.Wait()
+	if currentUserInterface, ok := c.GetRenderParam("User"); ok {
+		if currentUser_, ok := currentUserInterface.(*models.User); ok {
+			if currentUser_.ID == run.User.StringID() {
+				isUploader = true
+			}
+			if currentUser_.Admin {
+				isAdmin = true
+			}
+			currentUser = currentUser_
+		}
+	}
+
+	switch action := c.Req.PostFormValue("action"); action {
+	case "delete":
+		if isUploader {
+			analysis, runFile := run.FullAnalysis, run.RunFile
+			run.Deleted, run.RunFile, run.TotalTime, run.FullAnalysis = true, appengine.BlobKey(0), time.Duration(0), nil
+			run.Ranked, run.VerificationState = false, models.VerificationNone
+			if err := c.RunInTransaction(func(c *Context) error {
+				if err := updateBestRuns(c, run); err != nil {
+					return err
+				}
+				if err := updateMapTimes(c, run, nil); err != nil {
+					return err
+				}
+				if _, err := c.Goon.Put(run); err != nil {
+					return err
+				}
+
+				if err := c.Goon.Delete(analysis); err != nil && err != datastore.ErrNoSuchEntity {
+					return err
+				}
+
+				if err := taskqueue.Delete(c, &taskqueue.Task{Name: runKey.Encode()}, "runs"); err != nil {
+					if !strings.HasSuffix(err.Error(), "(taskqueue: TOMBSTONED_TASK)") { // TODO: This is ridiculously hacky because Google doesn't provide us with any decent way of detecting these errors.
+						return err
+					}
+				}
+
+				if err := blobstore.Delete(c, runFile); err != nil {
+					return err
+				}
+
+				return nil
+			}, nil); err != nil {
+				panic(err)
+			}
+
+			runURL, err := routerUrl("view-run", runKey.Encode())
+			if err != nil {
+				panic(err)
+			}
+			http.Redirect(c.Response, c.Req, runURL, http.StatusSeeOther)
+		} else {
+			c.Infof("Attempted to delete a run that they weren't the owner of.")
+			http.Error(c.Response, "You do not own this run.", http.StatusForbidden)
+			return
+		}
+	case "admin_delete":
+		if isAdmin {
+			reason := c.Req.PostFormValue("reason")
+			if len(reason) <= 0 {
+				http.Error(c.Response, "A reason is required.", http.StatusBadRequest)
+			}
+
+			uploader := &models.User{
+				ID: run.User.StringID(),
+			}
+			c.Step("fetch uploader", func(c *Context) {
+				if err := c.Goon.Get(uploader); err != nil {
+					if err == datastore.ErrNoSuchEntity {
+						uploader = nil
+					}
+					panic(err)
+				}
+			})
+			if uploader == nil {
+				c.Warningf("Unable to fetch run uploader (%s): doesn't exist", run.User.StringID())
+			}
+
+			analysis, runFile := run.FullAnalysis, run.RunFile
+			run.Deleted, run.RunFile, run.TotalTime, run.FullAnalysis = true, appengine.BlobKey(0), time.Duration(0), nil
+			run.Ranked, run.VerificationState = false, models.VerificationNone
+			if err := c.RunInTransaction(func(c *Context) error {
+				if err := updateBestRuns(c, run); err != nil {
+					return err
+				}
+				if err := updateMapTimes(c, run, nil); err != nil {
+					return err
+				}
+				if _, err := c.Goon.Put(run); err != nil {
+					return err
+				}
+
+				if err := c.Goon.Delete(analysis); err != nil && err != datastore.ErrNoSuchEntity {
+					return err
+				}
+
+				if err := taskqueue.Delete(c, &taskqueue.Task{Name: runKey.Encode()}, "runs"); err != nil {
+					if errStr := err.Error(); !strings.HasSuffix(errStr, "(taskqueue: TOMBSTONED_TASK)") && !strings.HasSuffix(errStr, "(taskqueue: UNKNOWN_TASK)") { // TODO: This is ridiculously hacky because Google doesn't provide us with any decent way of detecting these errors.
+						return err
+					}
+				}
+
+				if err := blobstore.Delete(c, runFile); err != nil {
+					return err
+				}
+
+				runURL, err := routerUrl("view-run", runKey.Encode())
+				if err != nil {
+					return err // TODO: Should we even bother returning an error here or should we just panic?
+				}
+				if parsedURL, err := c.Req.URL.Parse(runURL); err != nil {
+					return err
+				} else {
+					runURL = parsedURL.String()
+				}
+				if err := mail.SendToAdmins(c, &mail.Message{
+					Sender:  getAppEmail(c, "admin-team"),
+					Subject: fmt.Sprintf("Run %s deleted by %s", runKey.Encode(), currentUser.Nickname),
+
+					Body: fmt.Sprintf(`%s/(%s)/(%s) has deleted a run uploaded by (%s). The resides at %s.
+
+Reason given:
+%s`, currentUser.Nickname, currentUser.Email, currentUser.ID, run.User.StringID(), runURL, reason),
+				}); err != nil {
+					return err
+				}
+
+				if uploader != nil && len(uploader.Email) > 0 {
+					if err := mail.Send(c, &mail.Message{
+						Sender:  getAppEmail(c, "admin-team"),
+						To:      []string{uploader.Email},
+						Subject: fmt.Sprintf("Your run has been deleted"),
+
+						Body: fmt.Sprintf("Your run at %s has been deleted by an administrator. The reason given was:\n%s", runURL, reason),
+					}); err != nil {
+						c.Errorf("Error sending deletion email to user: %s", err)
+					}
+				} else {
+					c.Infof("Didn't send deletion mail to uploader: uploader doesn't exist or has no email address")
+				}
+
+				return nil
+			}, nil); err != nil {
+				panic(err)
+			}
+			c.Warningf("!!! ADMINISTRATOR %s DELETED RUN %s !!!\nREASON:\n%s", currentUser.ID, runKey.Encode(), reason)
+			http.Redirect(c.Response, c.Req, "/runs", http.StatusSeeOther)
+		} else {
+			c.Infof("Attempted to admin delete a run and they aren't an admin.")
+			http.Error(c.Response, "You must be an administrator to perform this action.", http.StatusForbidden)
+			return
+		}
+	case "trim":
+		if isUploader {
+			trimRun(c, run, c.Req.PostFormValue("trim_start") != "", c.Req.PostFormValue("trim_end") != "")
+		} else {
+			c.Infof("Attempted to trim a run that they weren't the owner of.")
+			http.Error(c.Response, "You do not own this run.", http.StatusForbidden)
+			return
+		}
+	case "relay":
+		if isUploader || (currentUser != nil && currentUser.CanVerify()) {
+			setRelay(c, run, currentUser)
+		} else {
+			c.Infof("Attempted to change the runners of a run that they weren't the owner or a verifier of.")
+			http.Error(c.Response, "You do not own this run.", http.StatusForbidden)
+			return
+		}
+	case "approve", "reject", "request_evidence":
+		if currentUser != nil && currentUser.CanVerify() {
+			verifyRun(c, run, currentUser, verificationActions[action])
+		} else {
+			c.Infof("Attempted to verify a run and they aren't a verifier.")
+			http.Error(c.Response, "You must be a verifier to perform this action.", http.StatusForbidden)
+			return
+		}
+	case "resubmit":
+		if isUploader {
+			resubmitRun(c, run, currentUser)
+		} else {
+			c.Infof("Attempted to resubmit a run that they weren't the owner of.")
+			http.Error(c.Response, "You do not own this run.", http.StatusForbidden)
+			return
+		}
+	case "reanalyze":
+		if isAdmin {
+			if len(run.RunFile) == 0 {
+				http.Error(c.Response, "This run's file no longer exists.", http.StatusBadRequest)
+				return
+			}
+
+			// The run keeps its times and verification until it has been analyzed, so that the analysis can tell whether they changed.
+			// It stays quarantined until it has been analyzed successfully.
+			oldAnalysis := run.FullAnalysis
+			run.FullAnalysis = nil
+			if err := c.RunInTransaction(func(c *Context) error {
+				if oldAnalysis != nil {
+					if err := c.Goon.Delete(oldAnalysis); err != nil && err != datastore.ErrNoSuchEntity {
+						return err
+					}
+				}
+
+				return queueAnalysis(c, run, false)
+			}, nil); err != nil {
+				panic(err)
+			}
+			c.Infof("Administrator %s queued run %s to be analyzed again", currentUser.ID, runKey.Encode())
+
+			runURL, err := routerUrl("view-run", runKey.Encode())
+			if err != nil {
+				panic(err)
+			}
+			http.Redirect(c.Response, c.Req, runURL, http.StatusSeeOther)
+		} else {
+			c.Infof("Attempted to re-analyze a run and they aren't an admin.")
+			http.Error(c.Response, "You must be an administrator to perform this action.", http.StatusForbidden)
+			return
+		}
+	default:
+		c.Infof("Unknown action: %s", action)
+		http.Error(c.Response, "Unknown action: "+action, http.StatusBadRequest)
+		return
+	}
+}
+
+// Adds context to errors that weren't caused by the contents of the run file.
+func describeReadError(err error, what string) error {
+	if isRunFileError(err) {
+		return err
+	}
+	return fmt.Errorf("Failed to read %s (%s)", what, err)
+}
+
+// Reports whether err is caused by what's in a run file, rather than by a problem reading it.
+// Analyzing the run again won't help with these.
+func isRunFileError(err error) bool {
+	_, ok := err.(*runfile.DecodeError)
+	return ok || err == analysis.ErrNoLines
+}
+
+// Records that the analysis of run failed. If err is a *runfile.DecodeError, the location of the problem is recorded so that the uploader can find it.
+// The run file is quarantined rather than deleted so that it can be inspected and analyzed again.
+func failedAnalysis(c *Context, run *models.Run, err error) error {
+	fullAnalysis := &models.Analysis{
+		Run: c.Goon.Key(run),
+
+		AnalyzerVersion: analysis.Version,
+
+		Fail:       true,
+		FailReason: err.Error(),
+	}
+	if run.FullAnalysis != nil {
+		fullAnalysis.ID = run.FullAnalysis.IntID() // Replace the old analysis.
+	}
+	if decodeErr, ok := err.(*runfile.DecodeError); ok {
+		fullAnalysis.FailReason, fullAnalysis.FailOffset, fullAnalysis.FailLine = decodeErr.Err.Error(), decodeErr.Offset, decodeErr.Line
+	}
+
+	c.Infof("Analysis failed: %s", err)
+	verificationState := run.VerificationState
+	return c.RunInTransaction(func(c *Context) error {
+		run.Quarantined, run.QuarantineTime = true, time.Now()
+		run.TotalTime, run.GameTime = time.Duration(0), time.Duration(0)
+		run.Partial, run.Flagged, run.Segmented, run.AnalysisPending = false, false, false, false
+		if _, err := c.Goon.Put(fullAnalysis); err != nil {
+			return err
+		}
+		run.FullAnalysis = c.Goon.Key(fullAnalysis) // Unfortunately, we can't do a PutMulti because we need to know the key of Analysis.
+		if verificationState != models.VerificationNone {
+			if err := addVerification(c, run, models.VerificationNone, "", "The run failed to be analyzed.", false); err != nil {
+				return err
+			}
+		}
+		if _, err := c.Goon.Put(run); err != nil {
+			return err
+		}
+
+		return nil
+	}, nil)
+}
+
+// Records an error that kept a run from being analyzed, such as the datastore or the blobstore being unavailable.
+// The task fails so that the queue retries it, unless the run has already been attempted maxAnalysisAttempts times,
+// in which case it's dead-lettered and left for an administrator to look into.
+func analysisAttemptFailed(c *Context, run *models.Run, attemptErr error) {
+	c.Errorf("Analysis attempt %d of %d failed: %s", run.AnalysisAttempts, maxAnalysisAttempts, attemptErr)
+	deadLettered := run.AnalysisAttempts >= maxAnalysisAttempts
+
+	c.Step("record failed attempt", func(c *Context) {
+		if err := c.RunInTransaction(func(c *Context) error {
+			// The run may have been changed by the attempt, so only the bookkeeping is carried over to a fresh copy.
+			stored := &models.Run{ID: run.ID, User: run.User}
+			if err := c.Goon.Get(stored); err != nil {
+				return err
+			}
+
+			stored.AnalysisAttempts, stored.LastAnalysisAttempt, stored.LastAnalysisError = run.AnalysisAttempts, run.LastAnalysisAttempt, attemptErr.Error()
+			if deadLettered {
+				stored.AnalysisPending, stored.DeadLettered = false, true
+			}
+			_, err := c.Goon.Put(stored)
+			return err
+		}, nil); err != nil {
+			panic(err)
+		}
+	})
+
+	if deadLettered {
+		c.Criticalf("Gave up on analyzing run %s after %d attempts.", c.Goon.Key(run).Encode(), run.AnalysisAttempts)
+		if _, err := io.WriteString(c.Response, "Run dead-lettered."); err != nil {
+			panic(err)
+		}
+		return
+	}
+	http.Error(c.Response, "Analysis attempt failed: "+attemptErr.Error(), http.StatusInternalServerError)
+}
+
+func ProcessRun(c *Context) {
+	runIDstr := c.Req.FormValue("id")
+	runKey, err := datastore.DecodeKey(runIDstr)
+	if err != nil {
+		c.Infof("Unable to decode run key (%s): %s", runIDstr, err)
+		http.Error(c.Response, "Unable to decode run key: "+runIDstr, http.StatusBadRequest)
+		return
+	}
+
+	run := &models.Run{ID: runKey.IntID(), User: runKey.Parent()}
+	stop := false // TODO: Make this feel less hacky
+	c.Step("fetch run", func(c *Context) {
+		if err := c.Goon.Get(run); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				NotFound(c)
+				stop = true
+				return
+			}
+			panic(err)
+		}
+	})
+	if stop {
+		return
+	}
+
+	if run.Deleted {
+		c.Infof("This run has been deleted.")
+		if _, err := io.WriteString(c.Response, "Run deleted."); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if run.FullAnalysis != nil && c.Req.FormValue("reanalyze") == "" {
+		c.Warningf("This run has already been analyzed.")
+		if _, err := io.WriteString(c.Response, "Run already analyzed."); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if run.DeadLettered {
+		c.Warningf("This run has been dead-lettered.")
+		if _, err := io.WriteString(c.Response, "Run dead-lettered."); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if run.AnalysisAttempts >= maxAnalysisAttempts {
+		// The earlier attempts died before they could record what went wrong, most likely by running out of time.
+		analysisAttemptFailed(c, run, fmt.Errorf("None of the %d attempts to analyze the run finished", run.AnalysisAttempts))
+		return
+	}
+
+	run.AnalysisAttempts++
+	run.LastAnalysisAttempt = time.Now()
+	c.Step("record attempt", func(c *Context) {
+		if _, err := c.Goon.Put(run); err != nil {
+			panic(err)
+		}
+	})
+
+	// Problems with the run file are permanent and fail the analysis, while anything else is worth another attempt.
+	fail := func(err error) {
+		if isRunFileError(err) {
+			if err := failedAnalysis(c, run, err); err != nil {
+				analysisAttemptFailed(c, run, err)
+			}
+			return
+		}
+		analysisAttemptFailed(c, run, err)
+	}
+
+	blobReader := blobstore.NewReader(c, run.RunFile)
+	runReader := runfile.NewReader(blobReader)
+
+	version, err := runReader.VerifyPreamble()
+	if err != nil {
+		fail(describeReadError(err, "the preamble"))
+		return
+	}
+	c.Infof("Run file version: %d", version)
+
+	header, err := runReader.ReadHeader()
+	if err != nil {
+		fail(describeReadError(err, "the run header"))
+		return
+	}
+
+	run.Game = int(header.Game)
+
+	c.Infof("Header: %#v", header)
+
+	// The category is fetched every time, since its ruleset may have changed since the run was last analyzed.
+	var category *models.Category
+	if len(run.Category) > 0 {
+		if category, err = fetchCategory(c, run.Category); err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	fullAnalysis := &models.Analysis{
+		Run: runKey,
+
+		Version:         int(version),
+		AnalyzerVersion: analysis.Version,
+		RawHeader:       header.MakeRaw(),
+	}
+	if run.FullAnalysis != nil {
+		fullAnalysis.ID = run.FullAnalysis.IntID() // Replace the old analysis.
+	}
+
+	old := *run
+	failed := false
+	c.Step("analyzing", func(c *Context) {
+		result, err := analysis.Analyze(runReader, header)
+		if decodeErr, ok := err.(*runfile.DecodeError); ok && analysis.Salvageable(result, err) {
+			// The run was probably cut short by a crash. Keep the maps that were finished so the run isn't a total loss.
+			c.Infof("Salvaged %d maps from a broken run: %s", len(result.Maps), decodeErr)
+
+			fullAnalysis.Partial = true
+			fullAnalysis.FailReason, fullAnalysis.FailOffset, fullAnalysis.FailLine = decodeErr.Err.Error(), decodeErr.Offset, decodeErr.Line
+		} else if err != nil {
+			fail(describeReadError(err, "the run's lines"))
+			failed = true
+			return
+		}
+		c.Infof("Analyzed %d lines: %d maps, players %q", result.Lines, len(result.Maps), result.Players)
+
+		fullAnalysis.Result = *result
+		if category != nil && category.Game != run.Game {
+			fullAnalysis.RuleViolations = []string{"The run's category is for a different game."}
+		} else if category != nil {
+			fullAnalysis.RuleViolations = category.Check(result)
+		} else if len(run.Category) > 0 {
+			fullAnalysis.RuleViolations = []string{"The run's category no longer exists."}
+		}
+		run.BreaksRules = len(fullAnalysis.RuleViolations) > 0
+		run.TotalTime, run.GameTime = result.TotalTime, result.GameTime
+		run.Partial, run.Flagged, run.Segmented = fullAnalysis.Partial, len(result.Flags) > 0, result.Segmented()
+		run.Quarantined, run.QuarantineTime = false, time.Time{}
+		run.AnalysisPending = false
+		if len(run.Runners) != len(result.Legs) {
+			run.Relay, run.Runners = false, nil // The legs have changed, so the runners have to be credited again.
+		}
+	})
+	if failed {
+		return
+	}
+
+	c.Step("insert analysis", func(c *Context) {
+		if err := c.RunInTransaction(func(c *Context) error {
+			if _, err := c.Goon.Put(fullAnalysis); err != nil {
+				return err
+			}
+			run.FullAnalysis = c.Goon.Key(fullAnalysis) // Unfortunately, we can't do a PutMulti because we need to know the key of Analysis.
+			if err := verifyAnalyzedRun(c, run, old); err != nil {
+				return err
+			}
+			if run.Ranked { // The run is still approved, but its maps may have been timed differently and its other runs may have changed.
+				if err := updateBestRuns(c, run); err != nil {
+					return err
+				}
+				if err := updateMapTimes(c, run, &fullAnalysis.Result); err != nil {
+					return err
+				}
+			}
+			if _, err := c.Goon.Put(run); err != nil {
+				return err
+			}
+
+			return nil
+		}, nil); err != nil {
+			analysisAttemptFailed(c, run, err)
+			failed = true
+		}
+	})
+	if failed {
+		return
+	}
+
+	c.Response.WriteHeader(http.StatusOK)
+	if _, err := io.WriteString(c.Response, "Successfully analyzed."); err != nil {
+		panic(err)
+	}
+}
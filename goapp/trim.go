@@ -0,0 +1,169 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine"
+	"appengine/blobstore"
+	"net/http"
+
+	"github.com/HL2-Ghosting-Team/website/models"
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+// Copies lines fromLine to toLine of a run file into a new blob, counting from 1. The times are moved so that the new run starts at 0.
+// The run must have been played in one segment.
+// If this fails, the new blob is never finalized, so it doesn't need to be deleted.
+func trimRunFile(c *Context, runFile appengine.BlobKey, fromLine, toLine int) (appengine.BlobKey, error) {
+	r := runfile.NewReader(blobstore.NewReader(c, runFile))
+	if _, err := r.VerifyPreamble(); err != nil {
+		return "", err
+	}
+	header, err := r.ReadHeader()
+	if err != nil {
+		return "", err
+	}
+
+	blobWriter, err := blobstore.Create(c, "application/octet-stream")
+	if err != nil {
+		return "", err
+	}
+	w := runfile.NewWriter(blobWriter)
+	if err := w.WritePreamble(); err != nil {
+		return "", err
+	}
+	if err := w.WriteHeader(header); err != nil {
+		return "", err
+	}
+
+	var (
+		line                runfile.Line
+		mapName, playerName string
+		startTime           float32
+	)
+	for n := 1; n <= toLine; n++ {
+		if err := r.ReadLineInto(&line); err != nil {
+			return "", err
+		}
+		if len(line.MapName) > 0 {
+			mapName = line.MapName
+		}
+		if len(line.PlayerName) > 0 {
+			playerName = line.PlayerName
+		}
+
+		if n < fromLine {
+			continue
+		} else if n == fromLine {
+			// The first line has to say where the run starts and who's playing, even if the line it came from didn't.
+			line.MapName, line.PlayerName = mapName, playerName
+			startTime = line.Time
+		}
+
+		line.Time -= startTime
+		if err := w.WriteLine(&line); err != nil {
+			return "", err
+		}
+	}
+
+	if err := blobWriter.Close(); err != nil {
+		return "", err
+	}
+	return blobWriter.Key()
+}
+
+// Cuts the idle time off the start and end of a run, then analyzes it again.
+// The trimmed run file is written first and only swapped in if the run hasn't changed in the meantime. The old run file is deleted once it has been.
+func trimRun(c *Context, run *models.Run, trimStart, trimEnd bool) {
+	runKey := c.Goon.Key(run)
+	if run.Deleted || run.AnalysisPending || len(run.RunFile) == 0 || run.FullAnalysis == nil {
+		http.Error(c.Response, "This run can't be trimmed.", http.StatusBadRequest)
+		return
+	}
+
+	fullAnalysis := &models.Analysis{ID: run.FullAnalysis.IntID(), Run: runKey}
+	c.Step("fetch analysis", func(c *Context) {
+		if err := c.Goon.Get(fullAnalysis); err != nil {
+			panic(err)
+		}
+	})
+	if fullAnalysis.Fail {
+		http.Error(c.Response, "This run can't be trimmed.", http.StatusBadRequest)
+		return
+	}
+	if fullAnalysis.Segmented() {
+		// Time is reset at the start of each segment, so moving the times back would put the later segments before the start.
+		http.Error(c.Response, "Segmented runs can't be trimmed.", http.StatusBadRequest)
+		return
+	}
+
+	fromLine, toLine := 1, fullAnalysis.Lines
+	if idle := fullAnalysis.LeadingIdle(); trimStart && idle != nil {
+		fromLine = idle.EndLine // Keep the last line where the player stood still, so that the run starts where they did.
+	}
+	if idle := fullAnalysis.TrailingIdle(); trimEnd && idle != nil {
+		toLine = idle.StartLine
+	}
+	if fromLine >= toLine || (fromLine == 1 && toLine == fullAnalysis.Lines) {
+		http.Error(c.Response, "There's nothing to trim.", http.StatusBadRequest)
+		return
+	}
+
+	oldRunFile := run.RunFile
+	var newRunFile appengine.BlobKey
+	c.Step("trim run file", func(c *Context) {
+		var err error
+		if newRunFile, err = trimRunFile(c, oldRunFile, fromLine, toLine); err != nil {
+			panic(err)
+		}
+	})
+	deleteNewRunFile := func() {
+		if err := blobstore.Delete(c, newRunFile); err != nil {
+			c.Errorf("Unable to delete the trimmed run file: %s", err)
+		}
+	}
+
+	// Another trim or an analysis may have changed the run while its file was being trimmed.
+	changed := false
+	c.Step("swap run file", func(c *Context) {
+		if err := c.RunInTransaction(func(c *Context) error {
+			current := &models.Run{ID: run.ID, User: run.User}
+			if err := c.Goon.Get(current); err != nil {
+				return err
+			}
+			if changed = current.Deleted || current.AnalysisPending || current.RunFile != oldRunFile; changed {
+				return nil
+			}
+
+			current.RunFile = newRunFile
+			if err := queueAnalysis(c, current, false); err != nil {
+				return err
+			}
+			*run = *current
+			return nil
+		}, nil); err != nil {
+			deleteNewRunFile()
+			panic(err)
+		}
+	})
+	if changed {
+		deleteNewRunFile()
+		http.Error(c.Response, "The run changed while it was being trimmed. Try again.", http.StatusConflict)
+		return
+	}
+	c.Infof("Trimmed run %s to lines %d to %d", runKey.Encode(), fromLine, toLine)
+
+	c.Step("delete old run file", func(c *Context) {
+		if err := blobstore.Delete(c, oldRunFile); err != nil {
+			c.Errorf("Unable to delete the untrimmed run file: %s", err)
+		}
+	})
+
+	runURL, err := routerUrl("view-run", runKey.Encode())
+	if err != nil {
+		panic(err)
+	}
+	http.Redirect(c.Response, c.Req, runURL, http.StatusSeeOther)
+}
@@ -1,95 +1,150 @@
-// Copyright 2009 Michael Johnson. All rights reserved.
-// Use of this source code is governed by the MIT
-// license that can be found in the LICENSE file.
-
-package goapp
-
-import (
-	"appengine/datastore"
-	"net/http"
-
-	"github.com/codegangsta/martini"
-
-	"github.com/HL2-Ghosting-Team/website/models"
-)
-
-var (
-	recentlyUploadedPerPage = 10
-	recentlyUploadedQuery   = datastore.NewQuery("Run").Order("-UploadTime").Project("UploadTime", "Game", "TotalTime", "RunFile").Limit(runsPerPage) // Get the top 10 runs for this game
-)
-
-type recentRunInternal struct {
-	Run       *models.Run
-	RunKey    *datastore.Key
-	RunStatus string
-}
-
-func ViewUser(c *Context, params martini.Params) {
-	// TODO: Do more with this
-	userIDstr := params["id"]
-	userKey, err := datastore.DecodeKey(userIDstr)
-	if err != nil {
-		c.Infof("Unable to decode user key (%s): %s", userIDstr, err)
-		http.Error(c.Response, "Invalid user ID: "+userIDstr, http.StatusBadRequest)
-		return
-	}
-
-	page := 0 // TODO: Actually check the page
-
-	recentlyUploadedChan := make(chan *models.Run, recentlyUploadedPerPage)
-	go c.Step("fetch recent runs", func(c *Context) {
-		defer close(recentlyUploadedChan)
-
-		q := recentlyUploadedQuery.Offset(page * recentlyUploadedPerPage).Ancestor(userKey)
-
-		for it := c.Goon.Run(q); ; {
-			run := new(models.Run)
-			if _, err := it.Next(run); err == datastore.Done {
-				break
-			} else if err != nil {
-				panic(err)
-			}
-
-			recentlyUploadedChan <- run
-		}
-	})
-
-	displayUserChan := make(chan *models.User, 1)
-	go c.Step("fetch display user", func(c *Context) {
-		defer close(displayUserChan)
-		displayUser := &models.User{ID: userKey.StringID()}
-		if err := c.Goon.Get(displayUser); err == datastore.ErrNoSuchEntity {
-			NotFound(c)
-			displayUserChan <- nil
-			return
-		} else if err != nil {
-			panic(err)
-		}
-		displayUserChan <- displayUser
-	})
-
-	displayUser := <-displayUserChan
-	if displayUser == nil {
-		return
-	}
-	c.SetRenderParam("DisplayUser", displayUser)
-
-	recentRuns := make([]*recentRunInternal, 0, recentlyUploadedPerPage)
-	for upload := range recentlyUploadedChan {
-		internalStruct := &recentRunInternal{
-			Run:    upload,
-			RunKey: c.Goon.Key(upload),
-		}
-		if upload.RunFile == "" {
-			internalStruct.RunStatus = "danger"
-		} else if upload.TotalTime == 0 {
-			internalStruct.RunStatus = "active"
-		} else {
-			internalStruct.RunStatus = "success"
-		}
-		recentRuns = append(recentRuns, internalStruct)
-	}
-	c.SetRenderParam("RecentRuns", recentRuns)
-
-	c.Render()
-}
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"net/http"
+
+	"github.com/codegangsta/martini"
+
+	"github.com/HL2-Ghosting-Team/website/models"
+)
+
+var (
+	recentlyUploadedPerPage = 10
+	recentlyUploadedQuery   = datastore.NewQuery("Run").Order("-UploadTime").Order("__key__")
+)
+
+type recentRunInternal struct {
+	Run       *models.Run
+	RunKey    *datastore.Key
+	RunStatus string
+}
+
+func ViewUser(c *Context, params martini.Params) {
+	// TODO: Do more with this
+	userIDstr := params["id"]
+	userKey, err := datastore.DecodeKey(userIDstr)
+	if err != nil {
+		c.Infof("Unable to decode user key (%s): %s", userIDstr, err)
+		http.Error(c.Response, "Invalid user ID: "+userIDstr, http.StatusBadRequest)
+		return
+	}
+
+	page := getPageToken(c, "users/"+userKey.StringID())
+
+	var next *pageToken
+	recentlyUploadedChan := make(chan *models.Run, recentlyUploadedPerPage)
+	go c.Step("fetch recent runs", func(c *Context) {
+		defer close(recentlyUploadedChan)
+
+		runs := make([]models.Run, recentlyUploadedPerPage)
+		n, nextPage, err := runPage(c, recentlyUploadedQuery.Ancestor(userKey), page, recentlyUploadedPerPage, func(i int) interface{} { return &runs[i] })
+		if err != nil {
+			panic(err)
+		}
+
+		next = nextPage
+		for i := 0; i < n; i++ {
+			recentlyUploadedChan <- &runs[i]
+		}
+	})
+
+	relayRunsChan := make(chan []*recentRunInternal, 1)
+	go c.Step("fetch relay runs", func(c *Context) {
+		q := datastore.NewQuery("Run").Filter("Runners =", userKey.StringID()).Order("-UploadTime").Limit(recentlyUploadedPerPage)
+
+		relayRuns := make([]*recentRunInternal, 0, recentlyUploadedPerPage)
+		for it := c.Goon.Run(q); ; {
+			run := new(models.Run)
+			if _, err := it.Next(run); err == datastore.Done {
+				break
+			} else if err != nil {
+				panic(err)
+			}
+
+			if run.Relay && !run.Deleted {
+				relayRuns = append(relayRuns, &recentRunInternal{Run: run, RunKey: c.Goon.Key(run)})
+			}
+		}
+		relayRunsChan <- relayRuns
+	})
+
+	displayUserChan := make(chan *models.User, 1)
+	go c.Step("fetch display user", func(c *Context) {
+		defer close(displayUserChan)
+		displayUser := &models.User{ID: userKey.StringID()}
+		if err := c.Goon.Get(displayUser); err == datastore.ErrNoSuchEntity {
+			NotFound(c)
+			displayUserChan <- nil
+			return
+		} else if err != nil {
+			panic(err)
+		}
+		displayUserChan <- displayUser
+	})
+
+	displayUser := <-displayUserChan
+	if displayUser == nil {
+		return
+	}
+	c.SetRenderParam("DisplayUser", displayUser)
+	c.SetRenderParam("DisplayUserKey", userKey)
+
+	recentRuns := make([]*recentRunInternal, 0, recentlyUploadedPerPage)
+	for upload := range recentlyUploadedChan {
+		internalStruct := &recentRunInternal{
+			Run:    upload,
+			RunKey: c.Goon.Key(upload),
+		}
+		if upload.RunFile == "" {
+			internalStruct.RunStatus = "danger"
+		} else if upload.TotalTime == 0 {
+			internalStruct.RunStatus = "active"
+		} else {
+			internalStruct.RunStatus = "success"
+		}
+		recentRuns = append(recentRuns, internalStruct)
+	}
+	c.SetRenderParam("RecentRuns", recentRuns)
+	c.SetRenderParam("FirstPage", page.Offset == 0)
+	c.SetRenderParam("PrevPage", page.previous(recentlyUploadedPerPage))
+	c.SetRenderParam("NextPage", next) // Read after the runs have all been received, so the query has finished.
+	c.SetRenderParam("RelayRuns", <-relayRunsChan)
+
+	c.Render()
+}
+
+// Gets the users with the given IDs, in the same order. Empty IDs are left nil, and users that no longer exist are replaced by CreateDeletedUser.
+func fetchUsers(c *Context, ids []string) []*models.User {
+	users := make([]*models.User, len(ids))
+	fetch := make([]*models.User, 0, len(ids))
+	for i, id := range ids {
+		if len(id) > 0 {
+			users[i] = &models.User{ID: id}
+			fetch = append(fetch, users[i])
+		}
+	}
+	if len(fetch) == 0 {
+		return users
+	}
+
+	if err := c.Goon.GetMulti(fetch); err != nil {
+		multiErr, ok := err.(appengine.MultiError)
+		if !ok {
+			panic(err)
+		}
+		for i, err := range multiErr {
+			if err == datastore.ErrNoSuchEntity {
+				*fetch[i] = *models.CreateDeletedUser()
+			} else if err != nil {
+				panic(err)
+			}
+		}
+	}
+	return users
+}
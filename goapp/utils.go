@@ -156,6 +156,19 @@ func (c *Context) createIncludes() {
 	})
 }
 
+// Returns the user making the request, or nil if they aren't logged in.
+// This waits for the includes to be created, so it must not be called while creating them.
+func (c *Context) CurrentUser() *models.User {
+	c.IncludesWG.Wait()
+
+	if currentUserInterface, ok := c.GetRenderParam("User"); ok {
+		if currentUser, ok := currentUserInterface.(*models.User); ok {
+			return currentUser
+		}
+	}
+	return nil
+}
+
 // Sets one of the render parameters.
 // This should be called rather than directly manipulating the map.
 func (c *Context) SetRenderParam(key string, value interface{}) {
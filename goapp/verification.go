@@ -0,0 +1,239 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package goapp
+
+import (
+	"appengine/datastore"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/models"
+)
+
+const verificationHistoryShown = 20
+
+// The actions that verifiers can take on a run, and the states that they put it in.
+var verificationActions = map[string]models.VerificationState{
+	"approve":          models.VerificationApproved,
+	"reject":           models.VerificationRejected,
+	"request_evidence": models.VerificationNeedsEvidence,
+}
+
+type verificationInternal struct {
+	Verification *models.Verification
+	Verifier     *models.User // Nil if the website made the change itself.
+	VerifierKey  *datastore.Key
+}
+
+// Changes the verification state of a run and records who changed it and why. Only approved runs are ranked, and only the best of them are on the leaderboards.
+// This should be called in the transaction that stores the run, after the run has a key.
+func addVerification(c *Context, run *models.Run, state models.VerificationState, verifier, reason string, flagsReviewed bool) error {
+	run.VerificationState, run.VerificationTime = state, time.Now()
+	run.Ranked = state == models.VerificationApproved
+	if err := updateBestRuns(c, run); err != nil {
+		return err
+	}
+	if err := updateMapTimes(c, run, nil); err != nil {
+		return err
+	}
+
+	_, err := c.Goon.Put(&models.Verification{
+		Run: c.Goon.Key(run),
+
+		State:         state,
+		Verifier:      verifier,
+		Time:          run.VerificationTime,
+		Reason:        reason,
+		FlagsReviewed: flagsReviewed,
+	})
+	return err
+}
+
+// Sends a run that has just been analyzed to be verified. An approved run goes back to being verified if analyzing it again changed its times or found something new.
+// old is the run as it was before it was analyzed. This should be called in the transaction that stores the run.
+func verifyAnalyzedRun(c *Context, run *models.Run, old models.Run) error {
+	switch old.VerificationState {
+	case models.VerificationNone:
+		return addVerification(c, run, models.VerificationPending, "", "The run was analyzed.", false)
+	case models.VerificationApproved:
+		if run.Partial {
+			return addVerification(c, run, models.VerificationPending, "", "The run was analyzed again, but its file is broken.", false)
+		} else if run.TotalTime != old.TotalTime || run.GameTime != old.GameTime {
+			return addVerification(c, run, models.VerificationPending, "", "The run was analyzed again and its times changed.", false)
+		} else if run.Flagged && !old.Flagged {
+			return addVerification(c, run, models.VerificationPending, "", "The run was analyzed again and was flagged.", false)
+		} else if run.BreaksRules && !old.BreaksRules {
+			return addVerification(c, run, models.VerificationPending, "", "The run was analyzed again and breaks its category's rules.", false)
+		}
+	}
+	return nil
+}
+
+// Explains why a run can't be approved, or returns an empty string if it can.
+func approvalProblem(run *models.Run, fullAnalysis *models.Analysis, flagsReviewed bool) string {
+	switch {
+	case run.Deleted:
+		return "Deleted runs can't be approved."
+	case run.AnalysisPending || fullAnalysis == nil:
+		return "The run hasn't been analyzed yet."
+	case fullAnalysis.Fail:
+		return "The run failed to be analyzed, so it can't be approved."
+	case run.Partial:
+		return "The run's file is broken, so it can't be approved."
+	case run.IndividualLevel && len(fullAnalysis.Maps) != 1:
+		return "An individual level run must only have one map in it."
+	case run.BreaksRules:
+		return "The run breaks its category's rules, so it can't be approved."
+	case run.Flagged && !flagsReviewed:
+		return "The analysis flagged this run. Review the flags before approving it."
+	}
+	return ""
+}
+
+// Approves or rejects a run, or asks its uploader for more evidence. Anything but approving the run needs a reason.
+func verifyRun(c *Context, run *models.Run, verifier *models.User, state models.VerificationState) {
+	runKey := c.Goon.Key(run)
+	reason := strings.TrimSpace(c.Req.PostFormValue("reason"))
+	flagsReviewed := c.Req.PostFormValue("flags_reviewed") != ""
+	if len(reason) == 0 && state != models.VerificationApproved {
+		http.Error(c.Response, "A reason is required.", http.StatusBadRequest)
+		return
+	}
+
+	problem := ""
+	c.Step("verify run", func(c *Context) {
+		if err := c.RunInTransaction(func(c *Context) error {
+			if err := c.Goon.Get(run); err != nil {
+				return err
+			}
+
+			if state == models.VerificationApproved {
+				var fullAnalysis *models.Analysis
+				if run.FullAnalysis != nil {
+					fullAnalysis = &models.Analysis{ID: run.FullAnalysis.IntID(), Run: runKey}
+					if err := c.Goon.Get(fullAnalysis); err == datastore.ErrNoSuchEntity {
+						fullAnalysis = nil
+					} else if err != nil {
+						return err
+					}
+				}
+				if problem = approvalProblem(run, fullAnalysis, flagsReviewed); len(problem) > 0 {
+					return nil
+				}
+			}
+
+			if err := addVerification(c, run, state, verifier.ID, reason, flagsReviewed && run.Flagged); err != nil {
+				return err
+			}
+			_, err := c.Goon.Put(run)
+			return err
+		}, nil); err != nil {
+			panic(err)
+		}
+	})
+	if len(problem) > 0 {
+		http.Error(c.Response, problem, http.StatusBadRequest)
+		return
+	}
+	c.Infof("Verifier %s changed run %s to %s: %s", verifier.ID, runKey.Encode(), state, reason)
+
+	runURL, err := routerUrl("view-run", runKey.Encode())
+	if err != nil {
+		panic(err)
+	}
+	http.Redirect(c.Response, c.Req, runURL, http.StatusSeeOther)
+}
+
+// Sends a run that needed more evidence back to be verified, along with the evidence that the uploader gave.
+func resubmitRun(c *Context, run *models.Run, uploader *models.User) {
+	runKey := c.Goon.Key(run)
+	evidence := strings.TrimSpace(c.Req.PostFormValue("evidence"))
+	if len(evidence) == 0 {
+		http.Error(c.Response, "Evidence is required.", http.StatusBadRequest)
+		return
+	}
+
+	resubmitted := false
+	c.Step("resubmit run", func(c *Context) {
+		if err := c.RunInTransaction(func(c *Context) error {
+			if err := c.Goon.Get(run); err != nil {
+				return err
+			}
+			if resubmitted = run.VerificationState == models.VerificationNeedsEvidence; !resubmitted {
+				return nil
+			}
+
+			if err := addVerification(c, run, models.VerificationPending, uploader.ID, evidence, false); err != nil {
+				return err
+			}
+			_, err := c.Goon.Put(run)
+			return err
+		}, nil); err != nil {
+			panic(err)
+		}
+	})
+	if !resubmitted {
+		http.Error(c.Response, "This run doesn't need more evidence.", http.StatusBadRequest)
+		return
+	}
+
+	runURL, err := routerUrl("view-run", runKey.Encode())
+	if err != nil {
+		panic(err)
+	}
+	http.Redirect(c.Response, c.Req, runURL, http.StatusSeeOther)
+}
+
+// Gets the latest changes to the verification of a run, newest first.
+func fetchVerifications(c *Context, runKey *datastore.Key) []*verificationInternal {
+	verifications := make([]*models.Verification, 0, verificationHistoryShown)
+	q := datastore.NewQuery("Verification").Ancestor(runKey).Order("-Time").Limit(verificationHistoryShown)
+	if _, err := c.Goon.GetAll(q, &verifications); err != nil {
+		panic(err)
+	}
+
+	ids := make([]string, len(verifications))
+	for i, verification := range verifications {
+		ids[i] = verification.Verifier
+	}
+	verifiers := fetchUsers(c, ids)
+
+	exposed := make([]*verificationInternal, len(verifications))
+	for i, verification := range verifications {
+		exposed[i] = &verificationInternal{
+			Verification: verification,
+			Verifier:     verifiers[i],
+		}
+		if verifiers[i] != nil && len(verifiers[i].ID) > 0 {
+			exposed[i].VerifierKey = c.Goon.Key(verifiers[i])
+		}
+	}
+	return exposed
+}
+
+// Lists the runs that are waiting to be verified, oldest first, and the runs that are waiting for more evidence.
+func VerificationQueue(c *Context) {
+	if currentUser := c.CurrentUser(); currentUser == nil || !currentUser.CanVerify() {
+		c.Infof("Attempted to view the verification queue and they aren't a verifier.")
+		http.Error(c.Response, "You must be a verifier to view this page.", http.StatusForbidden)
+		return
+	}
+
+	evidenceChan := make(chan []*adminRunInternal, 1)
+	go c.Step("fetch runs needing evidence", func(c *Context) {
+		q := datastore.NewQuery("Run").Filter("VerificationState =", string(models.VerificationNeedsEvidence)).Order("-VerificationTime")
+		evidenceChan <- fetchAdminRuns(c, q)
+	})
+
+	c.Step("fetch pending runs", func(c *Context) {
+		q := datastore.NewQuery("Run").Filter("VerificationState =", string(models.VerificationPending)).Order("VerificationTime")
+		c.SetRenderParam("PendingRuns", fetchAdminRuns(c, q))
+	})
+
+	c.SetRenderParam("NeedsEvidenceRuns", <-evidenceChan)
+
+	c.Render()
+}
@@ -0,0 +1,63 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/HL2-Ghosting-Team/website/analysis"
+)
+
+// A way of running a game, such as any% or 100%. Each category has its own leaderboard, and its runs have to follow its ruleset.
+type Category struct {
+	ID string `datastore:"-" goon:"id"` // A short name for the category, which is used in URLs.
+
+	Game        int
+	Name        string
+	Description string `datastore:",noindex"`
+
+	Ruleset
+}
+
+// The rules that the runs of a category have to follow. They're checked when a run is analyzed.
+type Ruleset struct {
+	Timing string `datastore:",noindex"` // How the leaderboard is ranked: "real" or "game". Empty lets the viewer choose.
+
+	AllowSegmented bool `datastore:",noindex"`
+	MaxLoads       int  `datastore:",noindex"` // How many times a save can be loaded. Negative means any number of times.
+
+	StartMap string `datastore:",noindex"` // The map that runs have to start on, if any.
+	EndMap   string `datastore:",noindex"` // The map that runs have to end on, if any.
+
+	// The sample rates that runs have to be recorded at, on top of the game's own limits. 0 means no limit.
+	MinSampleRate float64 `datastore:",noindex"`
+	MaxSampleRate float64 `datastore:",noindex"`
+}
+
+// Describes every rule that the analysis of a run breaks.
+func (r *Ruleset) Check(result *analysis.Result) []string {
+	var broken []string
+	if !r.AllowSegmented && result.Segmented() {
+		broken = append(broken, fmt.Sprintf("The run was played in %d segments, but segmented runs aren't allowed.", result.Segments))
+	}
+	if r.MaxLoads >= 0 && result.Loads > r.MaxLoads {
+		broken = append(broken, fmt.Sprintf("A save was loaded %d times, but only %d loads are allowed.", result.Loads, r.MaxLoads))
+	}
+	if len(result.Maps) > 0 {
+		if first := result.Maps[0].Name; len(r.StartMap) > 0 && first != r.StartMap {
+			broken = append(broken, fmt.Sprintf("The run starts on %s instead of %s.", first, r.StartMap))
+		}
+		if last := result.Maps[len(result.Maps)-1].Name; len(r.EndMap) > 0 && last != r.EndMap {
+			broken = append(broken, fmt.Sprintf("The run ends on %s instead of %s.", last, r.EndMap))
+		}
+	}
+	if r.MinSampleRate > 0 && result.SampleRate < r.MinSampleRate {
+		broken = append(broken, fmt.Sprintf("The run was recorded at %.0f lines a second, which is less than the %.0f that's required.", result.SampleRate, r.MinSampleRate))
+	}
+	if r.MaxSampleRate > 0 && result.SampleRate > r.MaxSampleRate {
+		broken = append(broken, fmt.Sprintf("The run was recorded at %.0f lines a second, which is more than the %.0f that's allowed.", result.SampleRate, r.MaxSampleRate))
+	}
+	return broken
+}
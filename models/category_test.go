@@ -0,0 +1,52 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/HL2-Ghosting-Team/website/analysis"
+)
+
+func TestRulesetCheck(t *testing.T) {
+	t.Parallel()
+
+	ruleset := &Ruleset{
+		MaxLoads:      -1,
+		StartMap:      "d1_trainstation_01",
+		EndMap:        "d3_breen_01",
+		MinSampleRate: 30,
+		MaxSampleRate: 300,
+	}
+	result := func(segments int, firstMap, lastMap string, sampleRate float64) *analysis.Result {
+		return &analysis.Result{
+			Maps:       []analysis.Map{{Name: firstMap}, {Name: lastMap}},
+			Segments:   segments,
+			Loads:      segments - 1,
+			SampleRate: sampleRate,
+		}
+	}
+
+	for _, test := range []struct {
+		name    string
+		ruleset Ruleset
+		result  *analysis.Result
+		broken  int
+	}{
+		{"follows the rules", *ruleset, result(1, "d1_trainstation_01", "d3_breen_01", 66), 0},
+		{"segmented", *ruleset, result(3, "d1_trainstation_01", "d3_breen_01", 66), 1},
+		{"segmented allowed", Ruleset{AllowSegmented: true, MaxLoads: -1}, result(3, "d1_trainstation_01", "d3_breen_01", 66), 0},
+		{"too many loads", Ruleset{AllowSegmented: true, MaxLoads: 1}, result(3, "d1_trainstation_01", "d3_breen_01", 66), 1},
+		{"wrong start", *ruleset, result(1, "d1_trainstation_02", "d3_breen_01", 66), 1},
+		{"wrong end", *ruleset, result(1, "d1_trainstation_01", "d3_citadel_05", 66), 1},
+		{"wrong start and end", *ruleset, result(1, "d1_trainstation_02", "d3_citadel_05", 66), 2},
+		{"any maps", Ruleset{MaxLoads: -1}, result(1, "d1_trainstation_02", "d3_citadel_05", 66), 0},
+		{"lowest sample rate", *ruleset, result(1, "d1_trainstation_01", "d3_breen_01", 30), 0},
+		{"sample rate too low", *ruleset, result(1, "d1_trainstation_01", "d3_breen_01", 29), 1},
+		{"highest sample rate", *ruleset, result(1, "d1_trainstation_01", "d3_breen_01", 300), 0},
+		{"sample rate too high", *ruleset, result(1, "d1_trainstation_01", "d3_breen_01", 301), 1},
+		{"any sample rate", Ruleset{MaxLoads: -1}, result(1, "d1_trainstation_01", "d3_breen_01", 1000), 0},
+	} {
+		if broken := test.ruleset.Check(test.result); len(broken) != test.broken {
+			t.Errorf("%s: expected %d rules to be broken, got %q", test.name, test.broken, broken)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"appengine/datastore"
+	"time"
+)
+
+// The time of one map of a ranked run, for the map leaderboards. There's one for every map of every ranked run, covering every visit to it,
+// and only the user's best time on each map is on its leaderboard. Each category has its own leaderboards, like the run leaderboards.
+type MapTime struct {
+	ID  int64          `datastore:"-" goon:"id"` // The order in which the map was first played in the run, starting at 1.
+	Run *datastore.Key `datastore:"-" goon:"parent"`
+
+	Game       int
+	Category   string // The ID of the run's category, or empty if it doesn't have one.
+	Map        string
+	TotalTime  time.Duration // Real time.
+	GameTime   time.Duration // Time without loads.
+	UploadTime time.Time     `datastore:",noindex"` // When the run was uploaded, which breaks ties.
+
+	BestTimings []string // The timings that this is the user's best time on the map in.
+}
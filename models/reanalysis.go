@@ -0,0 +1,26 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"time"
+)
+
+// A bulk re-analysis of the runs whose analyses were produced by an older version of the analysis.
+// The runs are walked in batches, and each batch records how far it got so that the next one can carry on from there.
+type ReanalysisJob struct {
+	ID int64 `datastore:"-" goon:"id"`
+
+	AnalyzerVersion int    `datastore:",noindex"` // The version of the analysis that runs are brought up to.
+	StartedBy       string `datastore:",noindex"` // The ID of the administrator that started the job.
+	StartTime       time.Time
+	FinishTime      time.Time `datastore:",noindex"`
+	Done            bool      `datastore:",noindex"` // Every run has been looked at. Some of them may still be waiting to be analyzed.
+
+	Cursor      string `datastore:",noindex"` // Where the next batch starts.
+	Batches     int    `datastore:",noindex"`
+	RunsScanned int    `datastore:",noindex"`
+	RunsQueued  int    `datastore:",noindex"`
+}
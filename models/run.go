@@ -1,195 +1,119 @@
-// Copyright 2009 Michael Johnson. All rights reserved.
-// Use of this source code is governed by the MIT
-// license that can be found in the LICENSE file.
-
-package models
-
-import (
-	"appengine"
-	"appengine/datastore"
-	"encoding/binary"
-	"encoding/gob"
-	"io"
-	"time"
-)
-
-const CurrentVersion = 0x00
-
-const (
-	GameHL2 = 0x00
-)
-
-const DefaultGame = GameHL2
-
-var PrettyGameNames = map[byte]string{
-	GameHL2: "Half-Life 2",
-}
-
-type Run struct {
-	ID      int64          `datastore:"-" json:"-" goon:"id"`
-	User    *datastore.Key `datastore:"-" json:"uploader" goon:"parent"`
-	Deleted bool           `datastore:",noindex" json:"-"`
-	Ranked  bool           `json:"ranked"`
-
-	UploadTime time.Time `json:"uploaded_at"`
-
-	Game         int               `json:"game"` // TODO: We'd like to use a single byte here, but App Engine doesn't support single bytes as a datastore type.
-	RunFile      appengine.BlobKey `datastore:",noindex" json:"-"`
-	TotalTime    time.Duration     `json:"-"`
-	FullAnalysis *datastore.Key    `datastore:",noindex" json:"-"`
-}
-
-func init() {
-	gob.Register(MapAnalysis{})
-}
-
-type MapAnalysis struct {
-	Name string
-	Time time.Duration
-}
-
-type Analysis struct {
-	ID  int64          `datastore:"-" goon:"id" json:"-"`
-	Run *datastore.Key `datastore:"-" goon:"parent" json:"-"`
-
-	RawHeader []byte     `json:"-"` // TODO: Unhackify.
-	Header    *RunHeader `datastore:"-" json:"header"`
-
-	Maps    []MapAnalysis `json:"maps"`
-	Players []string      `json:"runners"`
-
-	Fail       bool   `json:"failed"`
-	FailReason string `json:"fail_reason"`
-}
-
-func (a *Analysis) MakeHeader() {
-	if a.RawHeader != nil && len(a.RawHeader) >= 8 {
-		a.Header = &RunHeader{
-			Game: a.RawHeader[0],
-
-			GhostColorR: a.RawHeader[1],
-			GhostColorG: a.RawHeader[2],
-			GhostColorB: a.RawHeader[3],
-
-			TrailColorR: a.RawHeader[4],
-			TrailColorG: a.RawHeader[5],
-			TrailColorB: a.RawHeader[6],
-			TrailLength: a.RawHeader[7],
-		}
-	}
-}
-
-type RunReader struct {
-	io.Reader
-}
-
-type RunHeader struct {
-	Game byte
-
-	GhostColorR byte
-	GhostColorG byte
-	GhostColorB byte
-
-	TrailColorR byte
-	TrailColorG byte
-	TrailColorB byte
-	TrailLength byte
-}
-
-func (h *RunHeader) MakeRaw() []byte {
-	return []byte{h.Game, h.GhostColorR, h.GhostColorG, h.GhostColorB, h.TrailColorR, h.TrailColorG, h.TrailColorB, h.TrailLength}
-}
-
-func (h *RunHeader) TrailDuration() time.Duration {
-	return time.Duration(h.TrailLength) * time.Second
-}
-
-type RunLine struct {
-	MapName    string
-	PlayerName string
-
-	Time float32
-	X    float32
-	Y    float32
-	Z    float32
-}
-
-func readByte(r io.Reader) (byte, error) {
-	arr := make([]byte, 1)
-	if _, err := io.ReadFull(r, arr); err != nil {
-		return 0, err
-	}
-
-	return arr[0], nil
-}
-
-// Verifies the beginning of the file.
-// It always returns false if an error occurs.
-// It will return false if the first byte of the file is not 0xAF or the second byte is not a known verison of the run file format.
-func (r *RunReader) VerifyPreamble() (bool, error) {
-	if firstByte, err := readByte(r); err != nil {
-		return false, err
-	} else if firstByte != 0xAF {
-		return false, nil
-	}
-
-	if versionNumber, err := readByte(r); err != nil {
-		return false, err
-	} else if versionNumber != CurrentVersion {
-		return false, nil
-	}
-
-	return true, nil
-}
-
-func (r *RunReader) ReadHeader() (header *RunHeader, err error) {
-	header = new(RunHeader)
-	err = binary.Read(r, binary.LittleEndian, header)
-	return
-}
-
-func (r *RunReader) ReadLine() (*RunLine, error) {
-	mapNameLength, err := readByte(r)
-	if err != nil {
-		if err == io.ErrUnexpectedEOF {
-			err = io.EOF
-		}
-		return nil, err
-	}
-	mapNameArray := make([]byte, mapNameLength)
-	if _, err := io.ReadFull(r, mapNameArray); err != nil {
-		return nil, err
-	}
-	mapName := string(mapNameArray)
-
-	playerNameLength, err := readByte(r)
-	if err != nil {
-		return nil, err
-	}
-	playerNameArray := make([]byte, playerNameLength)
-	if _, err := io.ReadFull(r, playerNameArray); err != nil {
-		return nil, err
-	}
-	playerName := string(playerNameArray)
-
-	runLine := &RunLine{
-		MapName:    mapName,
-		PlayerName: playerName,
-	}
-
-	if err := binary.Read(r, binary.LittleEndian, &runLine.Time); err != nil {
-		return nil, err
-	}
-
-	if err := binary.Read(r, binary.LittleEndian, &runLine.X); err != nil {
-		return nil, err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &runLine.Y); err != nil {
-		return nil, err
-	}
-	if err := binary.Read(r, binary.LittleEndian, &runLine.Z); err != nil {
-		return nil, err
-	}
-
-	return runLine, nil
-}
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"encoding/gob"
+	"time"
+
+	"github.com/HL2-Ghosting-Team/website/analysis"
+	"github.com/HL2-Ghosting-Team/website/runfile"
+)
+
+const (
+	GameHL2 = 0x00
+)
+
+const DefaultGame = GameHL2
+
+var PrettyGameNames = map[byte]string{
+	GameHL2: "Half-Life 2",
+}
+
+type Run struct {
+	ID      int64          `datastore:"-" json:"-" goon:"id"`
+	User    *datastore.Key `datastore:"-" json:"uploader" goon:"parent"`
+	Deleted bool           `datastore:",noindex" json:"-"`
+	Ranked  bool           `json:"ranked"`                       // Only set by approving the run. See Verification.
+	Partial bool           `datastore:",noindex" json:"partial"` // The run file is broken, so only part of it could be analyzed. Partial runs must never be ranked.
+	Flagged bool           `json:"flagged"`                      // The analysis found something implausible, so a moderator should look at the run before it's ranked.
+
+	// The run file of a run that failed analysis is kept for a while so that it can be inspected. Only the uploader and administrators can download it.
+	Quarantined    bool      `json:"-"`
+	QuarantineTime time.Time `json:"-"`
+
+	// Bookkeeping for the task that analyzes the run. A run whose analysis keeps failing for reasons other than a broken run file
+	// is dead-lettered after too many attempts, so that an administrator can look into it and queue it again.
+	AnalysisPending     bool      `json:"-"` // The run is waiting to be analyzed.
+	AnalysisQueueTime   time.Time `json:"-"`
+	AnalysisAttempts    int       `datastore:",noindex" json:"-"`
+	LastAnalysisAttempt time.Time `datastore:",noindex" json:"-"`
+	LastAnalysisError   string    `datastore:",noindex" json:"-"`
+	DeadLettered        bool      `json:"-"`
+
+	// Runs are verified before they're ranked. The history of the verification is kept in the run's Verification entities.
+	VerificationState VerificationState `json:"verification"`
+	VerificationTime  time.Time         `json:"-"` // When the state last changed.
+
+	// Only a user's best ranked run for a game is on the leaderboards. Their other ranked runs are obsolete, but they're still in their history.
+	Obsolete    bool     `json:"obsolete"`
+	BestTimings []string `json:"-"` // The timings that this is the user's best run in.
+
+	// The ID of the category that the uploader picked, if the game has any. A run that breaks the ruleset of its category can't be ranked.
+	Category    string `json:"category"`
+	BreaksRules bool   `datastore:",noindex" json:"breaks_rules"`
+
+	// An individual level run only has one map in it. It's only ranked on the leaderboard of that map.
+	IndividualLevel bool `datastore:",noindex" json:"individual_level"`
+
+	// A relay run is played by several runners taking turns, and each leg of its analysis is credited to the user that played it.
+	Relay   bool     `datastore:",noindex" json:"relay"`
+	Runners []string `json:"runners,omitempty"` // The IDs of the users credited with each leg, in order. A leg that hasn't been credited has an empty ID.
+
+	UploadTime time.Time `json:"uploaded_at"`
+
+	Game         int               `json:"game"` // TODO: We'd like to use a single byte here, but App Engine doesn't support single bytes as a datastore type.
+	RunFile      appengine.BlobKey `datastore:",noindex" json:"-"`
+	TotalTime    time.Duration     `json:"-"`         // Real time.
+	GameTime     time.Duration     `json:"-"`         // Time without loads.
+	Segmented    bool              `json:"segmented"` // The run was played in more than one segment.
+	FullAnalysis *datastore.Key    `datastore:",noindex" json:"-"`
+}
+
+func init() {
+	gob.Register(analysis.Map{})
+}
+
+type Analysis struct {
+	ID  int64          `datastore:"-" goon:"id" json:"-"`
+	Run *datastore.Key `datastore:"-" goon:"parent" json:"-"`
+
+	Version         int             `datastore:",noindex" json:"version"` // The version of the run file format.
+	AnalyzerVersion int             `json:"analyzer_version"`             // The version of the analysis that produced this. Analyses from before it was recorded have 0.
+	RawHeader       []byte          `json:"-"`                            // TODO: Unhackify.
+	Header          *runfile.Header `datastore:"-" json:"header"`
+
+	analysis.Result
+
+	RuleViolations []string `datastore:",noindex" json:"rule_violations,omitempty"` // The rules of the run's category that it breaks.
+
+	// A partial analysis only covers the maps that were finished before the run file broke.
+	// FailReason, FailOffset and FailLine describe where it broke.
+	Partial bool `datastore:",noindex" json:"partial"`
+
+	Fail       bool   `json:"failed"`
+	FailReason string `json:"fail_reason"`
+	FailOffset int64  `datastore:",noindex" json:"fail_offset,omitempty"` // Where the run file is broken, if it couldn't be decoded.
+	FailLine   int    `datastore:",noindex" json:"fail_line,omitempty"`
+}
+
+func (a *Analysis) MakeHeader() {
+	if a.RawHeader != nil && len(a.RawHeader) >= 8 {
+		a.Header = &runfile.Header{
+			Game: a.RawHeader[0],
+
+			GhostColorR: a.RawHeader[1],
+			GhostColorG: a.RawHeader[2],
+			GhostColorB: a.RawHeader[3],
+
+			TrailColorR: a.RawHeader[4],
+			TrailColorG: a.RawHeader[5],
+			TrailColorB: a.RawHeader[6],
+			TrailLength: a.RawHeader[7],
+		}
+	}
+}
@@ -1,23 +1,29 @@
-// Copyright 2009 Michael Johnson. All rights reserved.
-// Use of this source code is governed by the MIT
-// license that can be found in the LICENSE file.
-
-package models
-
-// Creates a fake user to use in substitute of a user that's been deleted.
-func CreateDeletedUser() *User {
-	return &User{
-		Email:    "deleted@ghosting.nightexcessive.us",
-		Nickname: "[USER DELETED]",
-		Admin:    false,
-	}
-}
-
-type User struct {
-	ID string `datastore:"-" goon:"id"`
-
-	Email    string
-	Nickname string
-
-	Admin bool
-}
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package models
+
+// Creates a fake user to use in substitute of a user that's been deleted.
+func CreateDeletedUser() *User {
+	return &User{
+		Email:    "deleted@ghosting.nightexcessive.us",
+		Nickname: "[USER DELETED]",
+		Admin:    false,
+	}
+}
+
+type User struct {
+	ID string `datastore:"-" goon:"id"`
+
+	Email    string
+	Nickname string
+
+	Admin    bool
+	Verifier bool // The user can approve and reject runs. There's no page for this yet, so it's set by an administrator in the datastore viewer.
+}
+
+// Reports whether the user can approve and reject runs. Administrators always can.
+func (u *User) CanVerify() bool {
+	return u.Admin || u.Verifier
+}
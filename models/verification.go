@@ -0,0 +1,45 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"appengine/datastore"
+	"time"
+)
+
+// Where a run is in being verified. Only approved runs are ranked.
+type VerificationState string
+
+const (
+	VerificationNone          VerificationState = ""               // The run hasn't been analyzed successfully yet, so there's nothing to verify.
+	VerificationPending       VerificationState = "pending"        // The run is waiting for a verifier to look at it.
+	VerificationApproved      VerificationState = "approved"       // The run is ranked.
+	VerificationRejected      VerificationState = "rejected"       // The run will never be ranked.
+	VerificationNeedsEvidence VerificationState = "needs_evidence" // A verifier wants more evidence from the uploader, such as a video.
+)
+
+var PrettyVerificationStates = map[VerificationState]string{
+	VerificationNone:          "Not verified",
+	VerificationPending:       "Waiting to be verified",
+	VerificationApproved:      "Approved",
+	VerificationRejected:      "Rejected",
+	VerificationNeedsEvidence: "Needs more evidence",
+}
+
+func (s VerificationState) String() string {
+	return PrettyVerificationStates[s]
+}
+
+// A change to the verification state of a run. Every change is kept, so a run's verification can be audited.
+type Verification struct {
+	ID  int64          `datastore:"-" goon:"id"`
+	Run *datastore.Key `datastore:"-" goon:"parent"`
+
+	State         VerificationState `datastore:",noindex"`
+	Verifier      string            `datastore:",noindex"` // The ID of the user that made the change. Changes made by the website itself, such as after a run is analyzed, have none.
+	Time          time.Time
+	Reason        string `datastore:",noindex"`
+	FlagsReviewed bool   `datastore:",noindex"` // The verifier looked at what the analysis flagged before approving the run.
+}
@@ -0,0 +1,291 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package runfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Decodes run files. Reads from the underlying reader are buffered, so it may read past the end of the run.
+type Reader struct {
+	Version byte // The version of the run file format. This is only valid once the preamble has been verified.
+
+	rd         io.Reader
+	buf        []byte
+	start, end int   // The unread part of buf
+	err        error // The error returned by the last read from rd
+	offset     int64 // The number of bytes that have been decoded
+
+	names   map[string]string
+	decoder Decoder
+	line    int
+}
+
+// The size of the Reader's buffer. It must be large enough to hold the longest name.
+const readBufferSize = 4096
+
+// Give up if the underlying reader keeps returning nothing without an error.
+const maxEmptyReads = 100
+
+// Interning stops once this many distinct names have been seen so that a corrupted file can't use up all of our memory.
+const maxInternedNames = 1024
+
+// Decodes the header and lines of a single version of the run file format.
+// ReadLine should return io.EOF if there are no more lines. Problems with the contents of the file should be
+// reported using ErrTruncatedHeader, ErrTruncatedLine or ErrNameTooLong. The Reader adds the location.
+// Decoders should read using the Reader's Next and Intern methods so that lines can be decoded without allocating.
+type Decoder interface {
+	ReadHeader(r *Reader, header *Header) error
+	ReadLine(r *Reader, line *Line) error
+}
+
+var decoders = map[byte]Decoder{
+	0x00: version0Decoder{},
+}
+
+// Registers the decoder used for files of the given version.
+// This should only be called during initialization.
+func RegisterDecoder(version byte, decoder Decoder) {
+	if decoder == nil {
+		panic("runfile: RegisterDecoder decoder is nil")
+	}
+	if _, dup := decoders[version]; dup {
+		panic(fmt.Sprintf("runfile: RegisterDecoder called twice for version %d", version))
+	}
+
+	decoders[version] = decoder
+}
+
+// Reports whether files of the given version can be decoded.
+func IsSupportedVersion(version byte) bool {
+	_, ok := decoders[version]
+	return ok
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		rd:    r,
+		buf:   make([]byte, readBufferSize),
+		names: make(map[string]string),
+	}
+}
+
+// Returns the next n bytes. The returned slice is only valid until the next call to Next.
+// If fewer than n bytes are left, they are consumed and io.ErrUnexpectedEOF is returned, or io.EOF if there were none left at all.
+func (r *Reader) Next(n int) ([]byte, error) {
+	if n > len(r.buf) {
+		return nil, fmt.Errorf("runfile: %d bytes requested from a %d byte buffer", n, len(r.buf))
+	}
+
+	for emptyReads := 0; r.end-r.start < n && r.err == nil; {
+		if r.start > 0 {
+			copy(r.buf, r.buf[r.start:r.end])
+			r.end -= r.start
+			r.start = 0
+		}
+
+		var read int
+		read, r.err = r.rd.Read(r.buf[r.end:])
+		r.end += read
+
+		if read > 0 {
+			emptyReads = 0
+		} else if emptyReads++; emptyReads >= maxEmptyReads {
+			r.err = io.ErrNoProgress
+		}
+	}
+
+	if available := r.end - r.start; available < n {
+		r.start, r.offset = r.end, r.offset+int64(available)
+
+		err := r.err
+		if err == io.EOF && available > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	b := r.buf[r.start : r.start+n]
+	r.start, r.offset = r.start+n, r.offset+int64(n)
+	return b, nil
+}
+
+// Returns b as a string, reusing the string from a previous call if the same name has already been seen.
+// Runs only ever contain a handful of map and player names, so this saves an allocation for nearly every line.
+func (r *Reader) Intern(b []byte) string {
+	if name, ok := r.names[string(b)]; ok {
+		return name
+	}
+
+	name := string(b)
+	if len(r.names) < maxInternedNames {
+		r.names[name] = name
+	}
+	return name
+}
+
+func (r *Reader) decodeError(err error, offset int64) error {
+	switch err {
+	case ErrBadMagic, ErrUnsupportedVersion, ErrTruncatedHeader, ErrTruncatedLine, ErrNameTooLong:
+		return &DecodeError{Err: err, Offset: offset, Line: r.line}
+	}
+	return err
+}
+
+// Verifies the beginning of the file and returns the version of the run file format that it uses.
+// It returns a *DecodeError if the first byte of the file is not 0xAF or the second byte is not a known verison of the run file format.
+// Once the preamble has been verified, the header and lines are decoded using the decoder for the returned version.
+func (r *Reader) VerifyPreamble() (byte, error) {
+	if b, err := r.Next(1); err == io.EOF {
+		return 0, r.decodeError(ErrBadMagic, 0)
+	} else if err != nil {
+		return 0, err
+	} else if b[0] != magicNumber {
+		return 0, r.decodeError(ErrBadMagic, 0)
+	}
+
+	b, err := r.Next(1)
+	if err == io.EOF {
+		return 0, r.decodeError(ErrUnsupportedVersion, 1)
+	} else if err != nil {
+		return 0, err
+	}
+
+	version := b[0]
+	decoder, ok := decoders[version]
+	if !ok {
+		return version, r.decodeError(ErrUnsupportedVersion, 1)
+	}
+
+	r.Version, r.decoder = version, decoder
+	return version, nil
+}
+
+func (r *Reader) ReadHeader() (*Header, error) {
+	if r.decoder == nil {
+		return nil, errPreambleNotVerified
+	}
+
+	offset := r.offset
+	header := new(Header)
+	err := r.decoder.ReadHeader(r, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrTruncatedHeader
+	}
+	if err != nil {
+		return nil, r.decodeError(err, offset)
+	}
+	return header, nil
+}
+
+// Reads the next line. It returns io.EOF once there are no more lines.
+func (r *Reader) ReadLine() (*Line, error) {
+	line := new(Line)
+	if err := r.ReadLineInto(line); err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+// Reads the next line into line, overwriting all of its fields. It returns io.EOF once there are no more lines.
+// Unlike ReadLine, this doesn't allocate unless the line contains a name that hasn't been seen before.
+func (r *Reader) ReadLineInto(line *Line) error {
+	if r.decoder == nil {
+		return errPreambleNotVerified
+	}
+
+	offset := r.offset
+	r.line++
+	err := r.decoder.ReadLine(r, line)
+	if err == io.EOF && r.offset != offset {
+		err = ErrTruncatedLine
+	}
+	if err != nil {
+		return r.decodeError(err, offset)
+	}
+	return nil
+}
+
+// Returns the number of bytes that have been decoded so far.
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// The original run file format.
+type version0Decoder struct{}
+
+func (version0Decoder) ReadHeader(r *Reader, header *Header) error {
+	b, err := r.Next(8)
+	if err != nil {
+		return err
+	}
+
+	*header = Header{
+		Game: b[0],
+
+		GhostColorR: b[1],
+		GhostColorG: b[2],
+		GhostColorB: b[3],
+
+		TrailColorR: b[4],
+		TrailColorG: b[5],
+		TrailColorB: b[6],
+		TrailLength: b[7],
+	}
+	return nil
+}
+
+// Names are stored after a byte holding their length, so they can never be longer than the maximum.
+// Only running out of bytes before the length is a clean end of the file.
+func (version0Decoder) readName(r *Reader) (string, error) {
+	b, err := r.Next(1)
+	if err != nil {
+		return "", err
+	}
+
+	if b, err = r.Next(int(b[0])); err != nil {
+		return "", truncatedLine(err)
+	}
+	return r.Intern(b), nil
+}
+
+func (d version0Decoder) ReadLine(r *Reader, line *Line) error {
+	mapName, err := d.readName(r)
+	if err != nil {
+		return err
+	}
+
+	playerName, err := d.readName(r)
+	if err != nil {
+		return truncatedLine(err)
+	}
+
+	b, err := r.Next(16) // Time, X, Y and Z
+	if err != nil {
+		return truncatedLine(err)
+	}
+
+	*line = Line{
+		MapName:    mapName,
+		PlayerName: playerName,
+
+		Time: math.Float32frombits(binary.LittleEndian.Uint32(b[0:])),
+		X:    math.Float32frombits(binary.LittleEndian.Uint32(b[4:])),
+		Y:    math.Float32frombits(binary.LittleEndian.Uint32(b[8:])),
+		Z:    math.Float32frombits(binary.LittleEndian.Uint32(b[12:])),
+	}
+	return nil
+}
+
+// Hitting the end of the file after the start of a line means that the line was cut short.
+func truncatedLine(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncatedLine
+	}
+	return err
+}
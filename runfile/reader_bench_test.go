@@ -0,0 +1,138 @@
+package runfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// Builds a run that looks like a real one: the map changes every few thousand lines and the player name never does.
+func syntheticRun(b *testing.B, lines int) []byte {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	if err := w.WritePreamble(); err != nil {
+		b.Fatal(err)
+	}
+	if err := w.WriteHeader(&Header{Game: 0x00, TrailLength: 5}); err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < lines; i++ {
+		line := &Line{
+			MapName:    fmt.Sprintf("d1_synthetic_%02d", i/5000),
+			PlayerName: "runner",
+			Time:       float32(i) * 0.015,
+			X:          float32(i % 1000),
+			Y:          float32(i % 777),
+			Z:          float32(i % 13),
+		}
+		if err := w.WriteLine(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// The line decoder that Reader used before it was buffered. It's kept here to compare against.
+func legacyReadLine(r io.Reader) (*Line, error) {
+	mapNameLength, err := readByteLegacy(r)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	mapNameArray := make([]byte, mapNameLength)
+	if _, err := io.ReadFull(r, mapNameArray); err != nil {
+		return nil, err
+	}
+
+	playerNameLength, err := readByteLegacy(r)
+	if err != nil {
+		return nil, err
+	}
+	playerNameArray := make([]byte, playerNameLength)
+	if _, err := io.ReadFull(r, playerNameArray); err != nil {
+		return nil, err
+	}
+
+	runLine := &Line{
+		MapName:    string(mapNameArray),
+		PlayerName: string(playerNameArray),
+	}
+	for _, f := range []*float32{&runLine.Time, &runLine.X, &runLine.Y, &runLine.Z} {
+		if err := binary.Read(r, binary.LittleEndian, f); err != nil {
+			return nil, err
+		}
+	}
+
+	return runLine, nil
+}
+
+func readByteLegacy(r io.Reader) (byte, error) {
+	arr := make([]byte, 1)
+	if _, err := io.ReadFull(r, arr); err != nil {
+		return 0, err
+	}
+
+	return arr[0], nil
+}
+
+const benchmarkLines = 100000
+
+func BenchmarkReadLineLegacy(b *testing.B) {
+	run := syntheticRun(b, benchmarkLines)
+	b.SetBytes(int64(len(run)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r := bytes.NewReader(run[2+8:]) // Skip the preamble and header
+		for {
+			if _, err := legacyReadLine(r); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func benchmarkRunReader(b *testing.B, readLine func(r *Reader, line *Line) error) {
+	run := syntheticRun(b, benchmarkLines)
+	b.SetBytes(int64(len(run)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	line := new(Line)
+	for i := 0; i < b.N; i++ {
+		r := NewReader(bytes.NewReader(run))
+		if _, err := r.VerifyPreamble(); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := r.ReadHeader(); err != nil {
+			b.Fatal(err)
+		}
+		for {
+			if err := readLine(r, line); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkReadLine(b *testing.B) {
+	benchmarkRunReader(b, func(r *Reader, line *Line) error {
+		_, err := r.ReadLine()
+		return err
+	})
+}
+
+func BenchmarkReadLineInto(b *testing.B) {
+	benchmarkRunReader(b, (*Reader).ReadLineInto)
+}
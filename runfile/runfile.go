@@ -0,0 +1,82 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package runfile reads and writes the run files recorded by the ghosting plugin.
+// It has no dependencies on App Engine so that it can be used by plugin developers and offline tools.
+//
+// A run file starts with a two byte preamble (the magic number 0xAF and the version of the format),
+// followed by a Header and then a Line for every sample that the plugin recorded.
+package runfile
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const CurrentVersion = 0x00
+
+// The first byte of every run file.
+const magicNumber = 0xAF
+
+// The longest names that can be stored. Names are stored after a single byte holding their length, so this is as long as they can get.
+const (
+	MaxMapNameLength    = 0xff
+	MaxPlayerNameLength = 0xff
+)
+
+var (
+	ErrBadMagic           = errors.New("the file is not a run file")
+	ErrUnsupportedVersion = errors.New("the file uses an unsupported version of the run file format")
+	ErrTruncatedHeader    = errors.New("the file ends in the middle of the header")
+	ErrTruncatedLine      = errors.New("the file ends in the middle of a line")
+	ErrNameTooLong        = errors.New("a map or player name is too long")
+
+	errPreambleNotVerified = errors.New("the preamble must be verified before reading the rest of the file")
+)
+
+// A DecodeError describes a problem with the contents of a run file.
+type DecodeError struct {
+	Err    error // One of ErrBadMagic, ErrUnsupportedVersion, ErrTruncatedHeader, ErrTruncatedLine or ErrNameTooLong, or a problem found when analyzing a line.
+	Offset int64 // The position of the first byte of the preamble, header or line that couldn't be decoded.
+	Line   int   // The number of the line that couldn't be decoded, starting at 1. It is 0 if the problem is in the preamble or header.
+}
+
+func (e *DecodeError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s (line #%d, byte %d)", e.Err, e.Line, e.Offset)
+	}
+	return fmt.Sprintf("%s (byte %d)", e.Err, e.Offset)
+}
+
+type Header struct {
+	Game byte
+
+	GhostColorR byte
+	GhostColorG byte
+	GhostColorB byte
+
+	TrailColorR byte
+	TrailColorG byte
+	TrailColorB byte
+	TrailLength byte
+}
+
+func (h *Header) MakeRaw() []byte {
+	return []byte{h.Game, h.GhostColorR, h.GhostColorG, h.GhostColorB, h.TrailColorR, h.TrailColorG, h.TrailColorB, h.TrailLength}
+}
+
+func (h *Header) TrailDuration() time.Duration {
+	return time.Duration(h.TrailLength) * time.Second
+}
+
+type Line struct {
+	MapName    string
+	PlayerName string
+
+	Time float32
+	X    float32
+	Y    float32
+	Z    float32
+}
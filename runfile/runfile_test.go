@@ -0,0 +1,256 @@
+package runfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testHeader = &Header{
+	Game: 0x00,
+
+	GhostColorR: 255,
+	GhostColorG: 128,
+	GhostColorB: 0,
+
+	TrailColorR: 0,
+	TrailColorG: 64,
+	TrailColorB: 255,
+	TrailLength: 5,
+}
+
+var testLines = []*Line{
+	{MapName: "d1_trainstation_01", PlayerName: "runner", Time: 0, X: -14576, Y: -13424, Z: -3160},
+	{MapName: "", PlayerName: "", Time: 0.015, X: -14575.5, Y: -13423.25, Z: -3160},
+	{MapName: "d1_trainstation_02", PlayerName: "runner", Time: 61.5, X: -5120, Y: -4608, Z: 12.03125},
+	{MapName: "d1_trainstation_02", PlayerName: "someone else", Time: 62, X: 0, Y: 0, Z: 0},
+}
+
+func writeTestRun(t *testing.T, header *Header, lines []*Line) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	if err := w.WritePreamble(); err != nil {
+		t.Fatalf("Unable to write preamble: %s", err)
+	}
+	if err := w.WriteHeader(header); err != nil {
+		t.Fatalf("Unable to write header: %s", err)
+	}
+	for i, line := range lines {
+		if err := w.WriteLine(line); err != nil {
+			t.Fatalf("Unable to write line #%d: %s", i, err)
+		}
+	}
+
+	return buf
+}
+
+func TestRunRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	r := NewReader(writeTestRun(t, testHeader, testLines))
+
+	if version, err := r.VerifyPreamble(); err != nil {
+		t.Fatalf("Unable to verify preamble: %s", err)
+	} else if version != CurrentVersion {
+		t.Errorf("Expected version %d, got %d", CurrentVersion, version)
+	}
+
+	header, err := r.ReadHeader()
+	if err != nil {
+		t.Fatalf("Unable to read header: %s", err)
+	}
+	if !reflect.DeepEqual(header, testHeader) {
+		t.Errorf("Expected header %#v, got %#v", testHeader, header)
+	}
+
+	for i, expected := range testLines {
+		line, err := r.ReadLine()
+		if err != nil {
+			t.Fatalf("Unable to read line #%d: %s", i, err)
+		}
+		if !reflect.DeepEqual(line, expected) {
+			t.Errorf("Expected line #%d to be %#v, got %#v", i, expected, line)
+		}
+	}
+
+	if _, err := r.ReadLine(); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last line, got %v", err)
+	}
+}
+
+// Reads one byte at a time to make sure that lines split across reads are decoded correctly.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+func TestReadLineInto(t *testing.T) {
+	t.Parallel()
+
+	r := NewReader(oneByteReader{writeTestRun(t, testHeader, testLines)})
+	if _, err := r.VerifyPreamble(); err != nil {
+		t.Fatalf("Unable to verify preamble: %s", err)
+	}
+	if _, err := r.ReadHeader(); err != nil {
+		t.Fatalf("Unable to read header: %s", err)
+	}
+
+	line := new(Line)
+	for i, expected := range testLines {
+		if err := r.ReadLineInto(line); err != nil {
+			t.Fatalf("Unable to read line #%d: %s", i, err)
+		}
+		if *line != *expected {
+			t.Errorf("Expected line #%d to be %#v, got %#v", i, expected, line)
+		}
+	}
+
+	if err := r.ReadLineInto(line); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last line, got %v", err)
+	}
+	if expected := int64(writeTestRun(t, testHeader, testLines).Len()); r.Offset() != expected {
+		t.Errorf("Expected to have decoded %d bytes, got %d", expected, r.Offset())
+	}
+}
+
+func TestRunWriterNameTooLong(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	if err := w.WriteLine(&Line{MapName: strings.Repeat("a", MaxMapNameLength+1)}); err != ErrNameTooLong {
+		t.Errorf("Expected ErrNameTooLong for a long map name, got %v", err)
+	}
+	if err := w.WriteLine(&Line{PlayerName: strings.Repeat("a", MaxPlayerNameLength+1)}); err != ErrNameTooLong {
+		t.Errorf("Expected ErrNameTooLong for a long player name, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing to be written, got %d bytes", buf.Len())
+	}
+
+	if err := w.WriteLine(&Line{MapName: strings.Repeat("a", MaxMapNameLength), PlayerName: strings.Repeat("a", MaxPlayerNameLength)}); err != nil {
+		t.Errorf("Expected names at the maximum length to be written, got %s", err)
+	}
+}
+
+type testDecoder struct{}
+
+func (testDecoder) ReadHeader(r *Reader, header *Header) error {
+	header.Game = 0x42
+	return nil
+}
+
+func (testDecoder) ReadLine(r *Reader, line *Line) error {
+	return io.EOF
+}
+
+func TestVersionDispatch(t *testing.T) {
+	const testVersion = 0xFE
+	RegisterDecoder(testVersion, testDecoder{})
+
+	r := NewReader(bytes.NewReader([]byte{magicNumber, testVersion}))
+	if version, err := r.VerifyPreamble(); err != nil || version != testVersion {
+		t.Fatalf("Expected version %d to be verified, got %d, %v", testVersion, version, err)
+	}
+	if header, err := r.ReadHeader(); err != nil || header.Game != 0x42 {
+		t.Errorf("Expected the header to come from the registered decoder, got %#v, %v", header, err)
+	}
+}
+
+func decodeErrorHelper(t *testing.T, name string, err error, expected *DecodeError) {
+	if decodeErr, ok := err.(*DecodeError); !ok {
+		t.Errorf("%s: expected %#v, got %v", name, expected, err)
+	} else if *decodeErr != *expected {
+		t.Errorf("%s: expected %#v, got %#v", name, expected, decodeErr)
+	}
+}
+
+func TestVerifyPreambleRejects(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		preamble []byte
+		expected *DecodeError
+	}{
+		{[]byte{}, &DecodeError{Err: ErrBadMagic, Offset: 0}},
+		{[]byte{0x00, CurrentVersion}, &DecodeError{Err: ErrBadMagic, Offset: 0}},
+		{[]byte{magicNumber}, &DecodeError{Err: ErrUnsupportedVersion, Offset: 1}},
+		{[]byte{magicNumber, 0xFF}, &DecodeError{Err: ErrUnsupportedVersion, Offset: 1}},
+	} {
+		r := NewReader(bytes.NewReader(test.preamble))
+		_, err := r.VerifyPreamble()
+		decodeErrorHelper(t, fmt.Sprintf("preamble %v", test.preamble), err, test.expected)
+
+		if _, err := r.ReadHeader(); err == nil {
+			t.Errorf("Expected reading the header of %v to fail", test.preamble)
+		}
+	}
+}
+
+func readAllLines(r *Reader) error {
+	if _, err := r.VerifyPreamble(); err != nil {
+		return err
+	}
+	if _, err := r.ReadHeader(); err != nil {
+		return err
+	}
+	for {
+		if _, err := r.ReadLine(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	t.Parallel()
+
+	run := writeTestRun(t, testHeader, testLines).Bytes()
+	headerEnd := int64(2 + len(testHeader.MakeRaw()))
+	firstLineLength := int64(1 + len(testLines[0].MapName) + 1 + len(testLines[0].PlayerName) + 16)
+
+	err := readAllLines(NewReader(bytes.NewReader(run[:headerEnd-1])))
+	decodeErrorHelper(t, "truncated header", err, &DecodeError{Err: ErrTruncatedHeader, Offset: 2})
+
+	secondLineLength := int64(1 + len(testLines[1].MapName) + 1 + len(testLines[1].PlayerName) + 16)
+	for _, cut := range []int64{1, secondLineLength - 1} {
+		err = readAllLines(NewReader(bytes.NewReader(run[:headerEnd+firstLineLength+cut])))
+		decodeErrorHelper(t, fmt.Sprintf("line cut after %d bytes", cut), err, &DecodeError{Err: ErrTruncatedLine, Offset: headerEnd + firstLineLength, Line: 2})
+	}
+
+	// The first line's map name isn't empty, so this cuts it off in the middle.
+	err = readAllLines(NewReader(bytes.NewReader(run[:headerEnd+1+int64(len(testLines[0].MapName))/2])))
+	decodeErrorHelper(t, "map name cut short", err, &DecodeError{Err: ErrTruncatedLine, Offset: headerEnd, Line: 1})
+}
+
+func TestLongestNames(t *testing.T) {
+	t.Parallel()
+
+	lines := []*Line{{MapName: strings.Repeat("m", MaxMapNameLength), PlayerName: strings.Repeat("p", MaxPlayerNameLength), Time: 1}}
+	r := NewReader(writeTestRun(t, testHeader, lines))
+	if _, err := r.VerifyPreamble(); err != nil {
+		t.Fatalf("Unable to verify preamble: %s", err)
+	}
+	if _, err := r.ReadHeader(); err != nil {
+		t.Fatalf("Unable to read header: %s", err)
+	}
+	line, err := r.ReadLine()
+	if err != nil {
+		t.Fatalf("Unable to read line: %s", err)
+	}
+	if *line != *lines[0] {
+		t.Errorf("Expected %#v, got %#v", lines[0], line)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2009 Michael Johnson. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package runfile
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+type Writer struct {
+	io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w}
+}
+
+// Writes the beginning of the file: the magic number followed by the current version of the run file format.
+func (w *Writer) WritePreamble() error {
+	_, err := w.Write([]byte{magicNumber, CurrentVersion})
+	return err
+}
+
+func (w *Writer) WriteHeader(header *Header) error {
+	return binary.Write(w, binary.LittleEndian, header)
+}
+
+func writeName(w io.Writer, name string) error {
+	if _, err := w.Write([]byte{byte(len(name))}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, name)
+	return err
+}
+
+// Writes a single line. Nothing is written if either of the names are too long.
+func (w *Writer) WriteLine(line *Line) error {
+	if len(line.MapName) > MaxMapNameLength || len(line.PlayerName) > MaxPlayerNameLength {
+		return ErrNameTooLong
+	}
+
+	if err := writeName(w, line.MapName); err != nil {
+		return err
+	}
+	if err := writeName(w, line.PlayerName); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, []float32{line.Time, line.X, line.Y, line.Z})
+}